@@ -0,0 +1,78 @@
+package zeptomail
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// ParseAddress parses a single RFC 5322 address, such as
+// "Jane Doe <jane@example.com>" or "jane@example.com". The domain of the
+// address is normalised as by NormalizeAddress.
+func ParseAddress(s string) (EmailAddress, error) {
+	a, err := mail.ParseAddress(s)
+	if err != nil {
+		return EmailAddress{}, fmt.Errorf("invalid address %q: %w", s, err)
+	}
+	return AddressFromMail(a)
+}
+
+// ParseAddressList parses a comma separated list of RFC 5322 addresses.
+func ParseAddressList(s string) ([]EmailAddress, error) {
+	list, err := mail.ParseAddressList(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address list %q: %w", s, err)
+	}
+
+	rv := make([]EmailAddress, 0, len(list))
+	for _, a := range list {
+		addr, err := AddressFromMail(a)
+		if err != nil {
+			return nil, err
+		}
+		rv = append(rv, addr)
+	}
+	return rv, nil
+}
+
+// AddressFromMail converts a *mail.Address, normalising its domain.
+func AddressFromMail(a *mail.Address) (EmailAddress, error) {
+	if a == nil {
+		return EmailAddress{}, errors.New("nil address")
+	}
+	addr, err := NormalizeAddress(a.Address)
+	if err != nil {
+		return EmailAddress{}, err
+	}
+	return EmailAddress{Address: addr, Name: a.Name}, nil
+}
+
+// NormalizeAddress returns addr with its domain lower cased and, for
+// internationalised domains, converted to punycode, e.g.
+// "jane@Bücher.example" becomes "jane@xn--bcher-kva.example". The local
+// part is left untouched.
+func NormalizeAddress(addr string) (string, error) {
+	at := strings.LastIndexByte(addr, '@')
+	if at <= 0 || at == len(addr)-1 {
+		return "", fmt.Errorf("invalid address %q: missing local part or domain", addr)
+	}
+
+	domain, err := idna.Lookup.ToASCII(addr[at+1:])
+	if err != nil {
+		return "", fmt.Errorf("invalid domain in address %q: %w", addr, err)
+	}
+	return addr[:at+1] + domain, nil
+}
+
+// MailAddress converts a to a *mail.Address.
+func (a EmailAddress) MailAddress() *mail.Address {
+	return &mail.Address{Name: a.Name, Address: a.Address}
+}
+
+// String formats a as an RFC 5322 address, encoding the name if needed.
+func (a EmailAddress) String() string {
+	return a.MailAddress().String()
+}
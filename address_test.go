@@ -0,0 +1,70 @@
+package zeptomail_test
+
+import (
+	"net/mail"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/blancsoft/go-zeptomail"
+)
+
+func TestParseAddress(t *testing.T) {
+	tests := []struct {
+		in   string
+		want zeptomail.EmailAddress
+	}{
+		{"Jane Doe <jane@example.com>", zeptomail.EmailAddress{Name: "Jane Doe", Address: "jane@example.com"}},
+		{"jane@example.com", zeptomail.EmailAddress{Address: "jane@example.com"}},
+		{`"Doe, Jane" <jane@Example.COM>`, zeptomail.EmailAddress{Name: "Doe, Jane", Address: "jane@example.com"}},
+		{"=?utf-8?q?J=C3=BCrgen?= <j@example.com>", zeptomail.EmailAddress{Name: "Jürgen", Address: "j@example.com"}},
+		{"Jürgen <jürgen@bücher.example>", zeptomail.EmailAddress{Name: "Jürgen", Address: "jürgen@xn--bcher-kva.example"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := zeptomail.ParseAddress(tt.in)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	for _, in := range []string{"", "jane", "Jane <jane@>", "a@b.com, c@d.com"} {
+		_, err := zeptomail.ParseAddress(in)
+		assert.Error(t, err, in)
+	}
+}
+
+func TestParseAddressList(t *testing.T) {
+	got, err := zeptomail.ParseAddressList(`Jane <jane@example.com>, john@example.com`)
+	require.NoError(t, err)
+	assert.Equal(t, []zeptomail.EmailAddress{
+		{Name: "Jane", Address: "jane@example.com"},
+		{Address: "john@example.com"},
+	}, got)
+
+	_, err = zeptomail.ParseAddressList("jane@example.com, nope")
+	assert.Error(t, err)
+}
+
+func TestEmailAddressMailInterop(t *testing.T) {
+	addr := zeptomail.EmailAddress{Name: "Jürgen", Address: "j@example.com"}
+	assert.Equal(t, &mail.Address{Name: "Jürgen", Address: "j@example.com"}, addr.MailAddress())
+	assert.Equal(t, "=?utf-8?q?J=C3=BCrgen?= <j@example.com>", addr.String())
+
+	back, err := zeptomail.AddressFromMail(addr.MailAddress())
+	require.NoError(t, err)
+	assert.Equal(t, addr, back)
+
+	_, err = zeptomail.AddressFromMail(nil)
+	assert.Error(t, err)
+}
+
+func TestNormalizeAddress(t *testing.T) {
+	got, err := zeptomail.NormalizeAddress("Jane@Bücher.Example")
+	require.NoError(t, err)
+	assert.Equal(t, "Jane@xn--bcher-kva.example", got)
+
+	_, err = zeptomail.NormalizeAddress("@example.com")
+	assert.Error(t, err)
+}
@@ -0,0 +1,127 @@
+package zeptomail
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// MaxAttachmentSize is the largest attachment, in bytes, ZeptoMail accepts.
+// It is also the limit for the total size of an email.
+const MaxAttachmentSize = 15 << 20
+
+var (
+	// ErrAttachmentTooLarge is returned for attachments over MaxAttachmentSize.
+	ErrAttachmentTooLarge = errors.New("attachment exceeds the maximum size")
+
+	// ErrBlockedAttachment is returned for attachments whose file type
+	// ZeptoMail refuses to send.
+	ErrBlockedAttachment = errors.New("attachment file type is not supported")
+)
+
+// blockedExtensions are the file types listed as unsupported at
+// https://www.zoho.com/zeptomail/help/file-cache.html#alink-un-sup-for
+var blockedExtensions = map[string]bool{
+	".ade": true, ".adp": true, ".apk": true, ".appx": true, ".appxbundle": true,
+	".bat": true, ".cab": true, ".chm": true, ".cmd": true, ".com": true,
+	".cpl": true, ".dll": true, ".dmg": true, ".ex": true, ".ex_": true,
+	".exe": true, ".hta": true, ".ins": true, ".isp": true, ".iso": true,
+	".jar": true, ".js": true, ".jse": true, ".lib": true, ".lnk": true,
+	".mde": true, ".msc": true, ".msi": true, ".msix": true, ".msixbundle": true,
+	".msp": true, ".mst": true, ".nsh": true, ".pif": true, ".ps1": true,
+	".scr": true, ".sct": true, ".shb": true, ".sys": true, ".vb": true,
+	".vbe": true, ".vbs": true, ".vxd": true, ".wsc": true, ".wsf": true,
+	".wsh": true,
+}
+
+// checkAttachmentName returns ErrBlockedAttachment for names of a file type
+// ZeptoMail does not send.
+func checkAttachmentName(name string) error {
+	if blockedExtensions[strings.ToLower(path.Ext(name))] {
+		return fmt.Errorf("%s: %w", name, ErrBlockedAttachment)
+	}
+	return nil
+}
+
+// DetectContentType returns the MIME type of a file, from the extension of
+// its name when it is a known one, or else by sniffing its first bytes.
+func DetectContentType(name string, head []byte) string {
+	if t := mime.TypeByExtension(strings.ToLower(path.Ext(name))); t != "" {
+		return t
+	}
+	return http.DetectContentType(head)
+}
+
+// AttachmentFromBytes returns an attachment holding data, base64 encoded.
+func AttachmentFromBytes(name string, data []byte) (EmailAttachment, error) {
+	if err := checkAttachmentName(name); err != nil {
+		return EmailAttachment{}, err
+	}
+	if len(data) > MaxAttachmentSize {
+		return EmailAttachment{}, fmt.Errorf("%s: %w", name, ErrAttachmentTooLarge)
+	}
+	return EmailAttachment{
+		Content:  base64.StdEncoding.EncodeToString(data),
+		MimeType: DetectContentType(name, data),
+		Name:     name,
+	}, nil
+}
+
+// AttachmentFromReader reads r to its end and returns it as an attachment
+// called name.
+func AttachmentFromReader(name string, r io.Reader) (EmailAttachment, error) {
+	if err := checkAttachmentName(name); err != nil {
+		return EmailAttachment{}, err
+	}
+	// read one byte past the limit to tell a file of exactly the maximum
+	// size from a larger one, without reading all of the latter
+	data, err := io.ReadAll(io.LimitReader(r, MaxAttachmentSize+1))
+	if err != nil {
+		return EmailAttachment{}, fmt.Errorf("reading %s failed: %w", name, err)
+	}
+	return AttachmentFromBytes(name, data)
+}
+
+// AttachmentFromFile returns the file at path as an attachment named after
+// its base name.
+func AttachmentFromFile(path string) (EmailAttachment, error) {
+	return attachmentFromFile(os.DirFS(filepath.Dir(path)), filepath.Base(path), filepath.Base(path))
+}
+
+// AttachmentFromFS returns the file name of fsys as an attachment named
+// after its base name.
+func AttachmentFromFS(fsys fs.FS, name string) (EmailAttachment, error) {
+	return attachmentFromFile(fsys, name, path.Base(name))
+}
+
+func attachmentFromFile(fsys fs.FS, name, attachmentName string) (EmailAttachment, error) {
+	if err := checkAttachmentName(attachmentName); err != nil {
+		return EmailAttachment{}, err
+	}
+
+	f, err := fsys.Open(name)
+	if err != nil {
+		return EmailAttachment{}, err
+	}
+	defer func() { _ = f.Close() }()
+
+	info, err := f.Stat()
+	if err != nil {
+		return EmailAttachment{}, err
+	}
+	if info.IsDir() {
+		return EmailAttachment{}, fmt.Errorf("%s is a directory", name)
+	}
+	if info.Size() > MaxAttachmentSize {
+		return EmailAttachment{}, fmt.Errorf("%s: %w", attachmentName, ErrAttachmentTooLarge)
+	}
+	return AttachmentFromReader(attachmentName, f)
+}
@@ -0,0 +1,70 @@
+package zeptomail_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/blancsoft/go-zeptomail"
+)
+
+func TestAttachmentHelpers(t *testing.T) {
+	png := append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 32)...)
+
+	t.Run("from file", func(t *testing.T) {
+		p := filepath.Join(t.TempDir(), "report.pdf")
+		require.NoError(t, os.WriteFile(p, []byte("%PDF-1.7"), 0o600))
+
+		a, err := zeptomail.AttachmentFromFile(p)
+		require.NoError(t, err)
+		assert.Equal(t, "report.pdf", a.Name)
+		assert.Equal(t, "application/pdf", a.MimeType)
+		assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("%PDF-1.7")), a.Content)
+	})
+
+	t.Run("from fs", func(t *testing.T) {
+		fsys := fstest.MapFS{"img/logo": {Data: png}}
+		a, err := zeptomail.AttachmentFromFS(fsys, "img/logo")
+		require.NoError(t, err)
+		assert.Equal(t, "logo", a.Name)
+		assert.Equal(t, "image/png", a.MimeType, "sniffed without an extension")
+
+		decoded, err := base64.StdEncoding.DecodeString(a.Content)
+		require.NoError(t, err)
+		assert.Equal(t, png, decoded)
+	})
+
+	t.Run("from reader", func(t *testing.T) {
+		a, err := zeptomail.AttachmentFromReader("notes.txt", strings.NewReader("hello"))
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(a.MimeType, "text/plain"))
+	})
+
+	t.Run("blocked extension", func(t *testing.T) {
+		_, err := zeptomail.AttachmentFromReader("setup.EXE", strings.NewReader("MZ"))
+		assert.ErrorIs(t, err, zeptomail.ErrBlockedAttachment)
+
+		_, err = zeptomail.AttachmentFromFS(fstest.MapFS{"run.bat": {Data: []byte("echo")}}, "run.bat")
+		assert.ErrorIs(t, err, zeptomail.ErrBlockedAttachment)
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		big := bytes.NewReader(make([]byte, zeptomail.MaxAttachmentSize+1))
+		_, err := zeptomail.AttachmentFromReader("big.bin", big)
+		assert.ErrorIs(t, err, zeptomail.ErrAttachmentTooLarge)
+
+		fsys := fstest.MapFS{"big.bin": {Data: make([]byte, zeptomail.MaxAttachmentSize+1)}}
+		_, err = zeptomail.AttachmentFromFS(fsys, "big.bin")
+		assert.ErrorIs(t, err, zeptomail.ErrAttachmentTooLarge)
+
+		_, err = zeptomail.AttachmentFromBytes("max.bin", make([]byte, zeptomail.MaxAttachmentSize))
+		assert.NoError(t, err)
+	})
+}
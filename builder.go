@@ -0,0 +1,204 @@
+package zeptomail
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+)
+
+// MessageBuilder assembles a SendHTMLEmailReq or a SendTemplatedEmailReq.
+// Addresses are given as RFC 5322 strings such as "Jane Doe <jane@example.com>"
+// and parsed with ParseAddressList.
+// Errors are accumulated and reported by the Build methods, so calls can be
+// chained without checking each of them.
+type MessageBuilder struct {
+	from      EmailAddress
+	to        []SendEmailTo
+	options   BaseEmailOption
+	subject   string
+	html      string
+	text      string
+	mergeInfo map[string]any
+	errs      []error
+}
+
+// NewMessage returns an empty MessageBuilder.
+func NewMessage() *MessageBuilder {
+	return &MessageBuilder{mergeInfo: make(map[string]any)}
+}
+
+func (b *MessageBuilder) addresses(field string, lists []string) []EmailAddress {
+	var rv []EmailAddress
+	for _, list := range lists {
+		parsed, err := ParseAddressList(list)
+		if err != nil {
+			b.errs = append(b.errs, fmt.Errorf("%s: %w", field, err))
+			continue
+		}
+		rv = append(rv, parsed...)
+	}
+	return rv
+}
+
+func recipients(addrs []EmailAddress) []SendEmailTo {
+	rv := make([]SendEmailTo, 0, len(addrs))
+	for _, a := range addrs {
+		rv = append(rv, SendEmailTo{EmailAddress: a})
+	}
+	return rv
+}
+
+// From sets the sender.
+func (b *MessageBuilder) From(addr string) *MessageBuilder {
+	parsed, err := ParseAddress(addr)
+	if err != nil {
+		b.errs = append(b.errs, fmt.Errorf("from: %w", err))
+		return b
+	}
+	b.from = parsed
+	return b
+}
+
+// To adds recipients. Each argument may hold a comma separated list.
+func (b *MessageBuilder) To(addrs ...string) *MessageBuilder {
+	b.to = append(b.to, recipients(b.addresses("to", addrs))...)
+	return b
+}
+
+// CC adds carbon copy recipients.
+func (b *MessageBuilder) CC(addrs ...string) *MessageBuilder {
+	b.options.CC = append(b.options.CC, recipients(b.addresses("cc", addrs))...)
+	return b
+}
+
+// BCC adds blind carbon copy recipients.
+func (b *MessageBuilder) BCC(addrs ...string) *MessageBuilder {
+	b.options.BCC = append(b.options.BCC, recipients(b.addresses("bcc", addrs))...)
+	return b
+}
+
+// ReplyTo adds reply-to addresses.
+func (b *MessageBuilder) ReplyTo(addrs ...string) *MessageBuilder {
+	b.options.ReplyTo = append(b.options.ReplyTo, b.addresses("reply_to", addrs)...)
+	return b
+}
+
+// Subject sets the subject of an HTML email.
+func (b *MessageBuilder) Subject(subject string) *MessageBuilder {
+	b.subject = subject
+	return b
+}
+
+// HTML sets the HTML body of an HTML email.
+func (b *MessageBuilder) HTML(body string) *MessageBuilder {
+	b.html = body
+	return b
+}
+
+// Text sets the plain text body of an HTML email.
+func (b *MessageBuilder) Text(body string) *MessageBuilder {
+	b.text = body
+	return b
+}
+
+// Attach adds attachments.
+func (b *MessageBuilder) Attach(attachments ...EmailAttachment) *MessageBuilder {
+	b.options.Attachments = append(b.options.Attachments, attachments...)
+	return b
+}
+
+// Merge sets the merge tag key to value.
+func (b *MessageBuilder) Merge(key string, value any) *MessageBuilder {
+	b.mergeInfo[key] = value
+	return b
+}
+
+// MergeAll sets every merge tag of info.
+func (b *MessageBuilder) MergeAll(info map[string]any) *MessageBuilder {
+	maps.Copy(b.mergeInfo, info)
+	return b
+}
+
+// Header sets the additional MIME header name to value.
+func (b *MessageBuilder) Header(name string, value any) *MessageBuilder {
+	if err := ValidateHeader(name, value); err != nil {
+		b.errs = append(b.errs, err)
+		return b
+	}
+	if b.options.MimeHeaders == nil {
+		b.options.MimeHeaders = make(Headers)
+	}
+	b.options.MimeHeaders[name] = value
+	return b
+}
+
+// ClientReference sets the identifier used to track the transaction.
+func (b *MessageBuilder) ClientReference(ref string) *MessageBuilder {
+	b.options.ClientReference = ref
+	return b
+}
+
+// TrackClicks enables or disables click tracking.
+func (b *MessageBuilder) TrackClicks(enabled bool) *MessageBuilder {
+	b.options.TrackClicks = Bool(enabled)
+	return b
+}
+
+// TrackOpens enables or disables open tracking.
+func (b *MessageBuilder) TrackOpens(enabled bool) *MessageBuilder {
+	b.options.TrackOpens = Bool(enabled)
+	return b
+}
+
+// base returns copies of what the builder holds, so that a built request
+// is not affected by further calls on the builder.
+func (b *MessageBuilder) base() (BaseSendEmail, BaseEmailOption) {
+	opts := b.options
+	opts.MimeHeaders = maps.Clone(b.options.MimeHeaders)
+	return BaseSendEmail{
+		From:      b.from,
+		To:        append([]SendEmailTo(nil), b.to...),
+		MergeInfo: maps.Clone(b.mergeInfo),
+	}, opts
+}
+
+// BuildHTML returns the SendHTMLEmailReq described by the builder, or the
+// errors met while building and validating it.
+func (b *MessageBuilder) BuildHTML() (SendHTMLEmailReq, error) {
+	base, opts := b.base()
+	req := SendHTMLEmailReq{
+		BaseSendEmail:   base,
+		BaseEmailOption: opts,
+		Subject:         b.subject,
+		HtmlBody:        b.html,
+		TextBody:        b.text,
+	}
+
+	errs := append([]error(nil), b.errs...)
+	if err := validate.Struct(&req); err != nil {
+		errs = append(errs, err)
+	}
+	return req, errors.Join(errs...)
+}
+
+// BuildTemplated returns a SendTemplatedEmailReq for the template with the
+// given key or alias, or the errors met while building and validating it.
+// The subject and bodies come from the template, so setting them on the
+// builder is an error.
+func (b *MessageBuilder) BuildTemplated(templateKey string) (SendTemplatedEmailReq, error) {
+	base, opts := b.base()
+	req := SendTemplatedEmailReq{
+		BaseSendEmail:   base,
+		BaseEmailOption: opts,
+		TemplateKey:     templateKey,
+	}
+
+	errs := append([]error(nil), b.errs...)
+	if b.subject != "" || b.html != "" || b.text != "" {
+		errs = append(errs, errors.New("subject and body are set by the template"))
+	}
+	if err := validate.Struct(&req); err != nil {
+		errs = append(errs, err)
+	}
+	return req, errors.Join(errs...)
+}
@@ -0,0 +1,99 @@
+package zeptomail_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/blancsoft/go-zeptomail"
+)
+
+func TestMessageBuilder(t *testing.T) {
+	t.Run("html email", func(t *testing.T) {
+		req, err := zeptomail.NewMessage().
+			From("Blancsoft Tester <emailtesting.sender@blancsoft.com>").
+			To("Blancsoft Receiver <emailtesting.receiver@blancsoft.com>, Blancsoft Other <emailtesting.other@blancsoft.com>").
+			CC(`"Receiver, CC" <emailtesting.receiver@blancsoft.com>`).
+			ReplyTo("Blancsoft Tester <emailtesting.sender@blancsoft.com>").
+			Subject(emailSubject).
+			HTML(emailBody).
+			Text("Hello {{name}}").
+			Attach(attachment...).
+			Merge("name", "World").
+			Header("X-Tester", "go-zeptomail").
+			ClientReference("ref").
+			BuildHTML()
+		require.NoError(t, err)
+
+		assert.Equal(t, sender, req.From)
+		assert.Equal(t, []zeptomail.SendEmailTo{{EmailAddress: receiver}, {EmailAddress: other}}, req.To)
+		assert.Equal(t, "Receiver, CC", req.CC[0].EmailAddress.Name)
+		assert.Equal(t, []zeptomail.EmailAddress{sender}, req.ReplyTo)
+		assert.Equal(t, emailSubject, req.Subject)
+		assert.Equal(t, emailBody, req.HtmlBody)
+		assert.Equal(t, "Hello {{name}}", req.TextBody)
+		assert.Equal(t, attachment, req.Attachments)
+		assert.Equal(t, map[string]any{"name": "World"}, req.MergeInfo)
+		assert.Equal(t, "go-zeptomail", req.MimeHeaders["X-Tester"])
+		assert.Equal(t, "ref", req.ClientReference)
+	})
+
+	t.Run("templated email", func(t *testing.T) {
+		req, err := zeptomail.NewMessage().
+			From("Blancsoft Tester <emailtesting.sender@blancsoft.com>").
+			To("Blancsoft Receiver <emailtesting.receiver@blancsoft.com>").
+			Merge("name", "World").
+			BuildTemplated("tmpl-key")
+		require.NoError(t, err)
+		assert.Equal(t, "tmpl-key", req.TemplateKey)
+		assert.Equal(t, sender, req.From)
+	})
+
+	t.Run("errors are accumulated", func(t *testing.T) {
+		_, err := zeptomail.NewMessage().
+			From("not an address").
+			To("Receiver <receiver@>").
+			Subject(emailSubject).
+			BuildHTML()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `from: invalid address "not an address"`)
+		assert.Contains(t, err.Error(), `to: invalid address list "Receiver <receiver@>"`)
+		assert.Contains(t, err.Error(), "HtmlBody")
+	})
+
+	t.Run("from takes a single address", func(t *testing.T) {
+		_, err := zeptomail.NewMessage().
+			From("a@blancsoft.com, b@blancsoft.com").
+			To(receiver.Address).
+			Subject(emailSubject).
+			HTML(emailBody).
+			BuildHTML()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `from: invalid address "a@blancsoft.com, b@blancsoft.com"`)
+	})
+
+	t.Run("template with body", func(t *testing.T) {
+		_, err := zeptomail.NewMessage().
+			From("Blancsoft Tester <emailtesting.sender@blancsoft.com>").
+			To("Blancsoft Receiver <emailtesting.receiver@blancsoft.com>").
+			Subject("ignored").
+			BuildTemplated("tmpl-key")
+		assert.ErrorContains(t, err, "set by the template")
+	})
+
+	t.Run("built requests are independent", func(t *testing.T) {
+		b := zeptomail.NewMessage().
+			From("Blancsoft Tester <emailtesting.sender@blancsoft.com>").
+			To("Blancsoft Receiver <emailtesting.receiver@blancsoft.com>").
+			Subject(emailSubject).
+			HTML(emailBody).
+			Merge("name", "First")
+		first, err := b.BuildHTML()
+		require.NoError(t, err)
+		second, err := b.Merge("name", "Second").BuildHTML()
+		require.NoError(t, err)
+		assert.Equal(t, "First", first.MergeInfo["name"])
+		assert.Equal(t, "Second", second.MergeInfo["name"])
+	})
+}
@@ -2,7 +2,14 @@ package zeptomail_test
 
 import (
 	_ "embed"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/blancsoft/go-zeptomail"
 )
 
 var (
@@ -12,3 +19,33 @@ var (
 	zeptoMailToken     = os.Getenv("ZEPTO_MAIL_TOKEN")
 	zeptoMailMgmtToken = os.Getenv("ZEPTO_MAIL_MGMT_TOKEN")
 )
+
+// sendAccepted is the body ZeptoMail returns for an accepted send request.
+const sendAccepted = `{"data":[{"code":"EM_104","additional_info":[],"message":"Email request received"}],"message":"OK","request_id":"req-1","object":"email"}`
+
+// roundTripFunc serves API calls from within the test process.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+// newTestClient returns a client whose requests are answered by handler
+// instead of the ZeptoMail API.
+func newTestClient(t *testing.T, handler http.HandlerFunc) *zeptomail.Client {
+	t.Helper()
+
+	transport := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		rec := httptest.NewRecorder()
+		handler(rec, r)
+		return rec.Result(), nil
+	})
+	client, err := zeptomail.NewClient("test-agent", "Zoho-enczapikey test", &http.Client{Transport: transport})
+	require.NoError(t, err)
+	return client
+}
+
+// acceptHandler answers every request with a successful send response.
+func acceptHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write([]byte(sendAccepted))
+}
@@ -0,0 +1,515 @@
+package zeptomail
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/andybalholm/cascadia"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// InlineCSS moves the rules of the <style> blocks of body into the style
+// attributes of the elements they apply to, as many mail clients drop
+// style blocks. Declarations are applied in cascade order: by importance,
+// specificity and position, with style attributes already in body taking
+// precedence over rules that are not !important.
+//
+// At-rules, such as media queries, and rules whose selectors cannot be
+// inlined, such as :hover or ::before, are kept in a <style> block in place
+// of the first one. The selectors that could not be inlined are returned.
+//
+// Only style blocks and the tags gaining a style attribute are rewritten:
+// the rest of body, merge tags and sections included, is kept as written,
+// and fragments are not made into complete documents. Selectors match the
+// tags as written, without the elements a browser would imply.
+func InlineCSS(body string) (string, []string, error) {
+	doc, elements, err := parseTags(body)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var styles []*html.Node
+	for _, n := range elements {
+		if n.DataAtom == atom.Style {
+			styles = append(styles, n)
+		}
+	}
+	if len(styles) == 0 {
+		return body, nil, nil
+	}
+
+	var (
+		matches     = make(map[*html.Node][]cssMatch)
+		unsupported []string
+		kept        []string
+		order       int
+	)
+	for _, style := range styles {
+		var css strings.Builder
+		for c := style.FirstChild; c != nil; c = c.NextSibling {
+			css.WriteString(c.Data)
+		}
+		rules, atRules := parseStylesheet(css.String())
+		kept = append(kept, atRules...)
+
+		for _, rule := range rules {
+			for _, selector := range splitSelectors(rule.selectors) {
+				sel, err := cascadia.Parse(selector)
+				if err != nil || isDynamicSelector(selector) {
+					unsupported = append(unsupported, selector)
+					kept = append(kept, selector+" { "+formatDeclarations(rule.decls)+" }")
+					continue
+				}
+				walkNodes(doc, func(n *html.Node) bool {
+					if n.Type != html.ElementNode {
+						return true
+					}
+					if n.DataAtom == atom.Head || n.DataAtom == atom.Style {
+						return false
+					}
+					if sel.Match(n) {
+						for _, d := range rule.decls {
+							matches[n] = append(matches[n], cssMatch{sel.Specificity(), order, d})
+							order++
+						}
+					}
+					return true
+				})
+			}
+		}
+	}
+
+	for n, m := range matches {
+		inlineDeclarations(n, m)
+	}
+	var keptStyle string
+	if len(kept) > 0 {
+		keptStyle = "<style>\n" + strings.Join(kept, "\n") + "\n</style>"
+	}
+
+	// write body back, swapping the tags of matched elements and the style
+	// blocks
+	var (
+		out        strings.Builder
+		z          = html.NewTokenizer(strings.NewReader(body))
+		index      int
+		inStyle    bool
+		styleFound bool
+	)
+	out.Grow(len(body))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if err := z.Err(); err != io.EOF {
+				return "", nil, err
+			}
+			return out.String(), unsupported, nil
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			if inStyle {
+				if tt == html.EndTagToken {
+					if name, _ := z.TagName(); string(name) == "style" {
+						inStyle = false
+					}
+				}
+				continue
+			}
+			out.Write(z.Raw())
+			continue
+		}
+
+		n := elements[index]
+		index++
+		switch {
+		case n.DataAtom == atom.Style:
+			if !styleFound {
+				out.WriteString(keptStyle)
+				styleFound = true
+			}
+			inStyle = tt == html.StartTagToken
+		case inStyle:
+		case matches[n] != nil:
+			out.WriteString(html.Token{Type: tt, DataAtom: n.DataAtom, Data: n.Data, Attr: n.Attr}.String())
+		default:
+			out.Write(z.Raw())
+		}
+	}
+}
+
+// impliedEnds lists, for the tags implying the end of an open element, the
+// elements they end, e.g. a <li> ends the <li> before it.
+var impliedEnds = map[atom.Atom][]atom.Atom{
+	atom.Li:     {atom.Li},
+	atom.P:      {atom.P},
+	atom.Dt:     {atom.Dt, atom.Dd},
+	atom.Dd:     {atom.Dt, atom.Dd},
+	atom.Option: {atom.Option},
+	atom.Td:     {atom.Td, atom.Th},
+	atom.Th:     {atom.Td, atom.Th},
+	atom.Tr:     {atom.Td, atom.Th, atom.Tr},
+}
+
+// voidElements have no content nor end tag.
+var voidElements = map[atom.Atom]bool{
+	atom.Area: true, atom.Base: true, atom.Br: true, atom.Col: true, atom.Embed: true,
+	atom.Hr: true, atom.Img: true, atom.Input: true, atom.Link: true, atom.Meta: true,
+	atom.Source: true, atom.Track: true, atom.Wbr: true,
+}
+
+// parseTags builds the tree of the tags of body as written. Unlike
+// html.Parse, it neither adds the elements a document implies nor moves
+// misplaced content, such as text in a <table>, so that every element maps
+// back to its start tag. Elements are returned in the order of their start
+// tags.
+func parseTags(body string) (*html.Node, []*html.Node, error) {
+	var (
+		root     = &html.Node{Type: html.DocumentNode}
+		open     = []*html.Node{root}
+		elements []*html.Node
+		z        = html.NewTokenizer(strings.NewReader(body))
+	)
+	for {
+		switch tt := z.Next(); tt {
+		case html.ErrorToken:
+			if err := z.Err(); err != io.EOF {
+				return nil, nil, err
+			}
+			return root, elements, nil
+		case html.TextToken:
+			open[len(open)-1].AppendChild(&html.Node{Type: html.TextNode, Data: string(z.Text())})
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tok := z.Token()
+			for len(open) > 1 && slices.Contains(impliedEnds[tok.DataAtom], open[len(open)-1].DataAtom) {
+				open = open[:len(open)-1]
+			}
+			n := &html.Node{Type: html.ElementNode, Data: tok.Data, DataAtom: tok.DataAtom, Attr: tok.Attr}
+			open[len(open)-1].AppendChild(n)
+			elements = append(elements, n)
+			if tt == html.StartTagToken && !voidElements[tok.DataAtom] {
+				open = append(open, n)
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			for i := len(open) - 1; i > 0; i-- {
+				if open[i].Data == string(name) {
+					open = open[:i]
+					break
+				}
+			}
+		}
+	}
+}
+
+type cssRule struct {
+	selectors string
+	decls     []cssDeclaration
+}
+
+type cssDeclaration struct {
+	property  string
+	value     string
+	important bool
+}
+
+type cssMatch struct {
+	specificity cascadia.Specificity
+	order       int
+	decl        cssDeclaration
+}
+
+// inlineDeclarations merges the declarations matched by n into its style
+// attribute.
+func inlineDeclarations(n *html.Node, matches []cssMatch) {
+	slices.SortStableFunc(matches, func(a, b cssMatch) int {
+		switch {
+		case a.specificity.Less(b.specificity):
+			return -1
+		case b.specificity.Less(a.specificity):
+			return 1
+		}
+		return a.order - b.order
+	})
+
+	var (
+		props  []string
+		values = make(map[string]string)
+	)
+	apply := func(d cssDeclaration) {
+		if _, ok := values[d.property]; !ok {
+			props = append(props, d.property)
+		}
+		values[d.property] = d.value
+	}
+
+	var existing []cssDeclaration
+	for i, a := range n.Attr {
+		if a.Namespace == "" && strings.EqualFold(a.Key, "style") {
+			existing = parseDeclarations(a.Val)
+			n.Attr = slices.Delete(n.Attr, i, i+1)
+			break
+		}
+	}
+	for _, important := range []bool{false, true} {
+		for _, m := range matches {
+			if m.decl.important == important {
+				apply(m.decl)
+			}
+		}
+		for _, d := range existing {
+			if d.important == important {
+				apply(d)
+			}
+		}
+	}
+
+	decls := make([]cssDeclaration, 0, len(props))
+	for _, p := range props {
+		decls = append(decls, cssDeclaration{property: p, value: values[p]})
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: "style", Val: formatDeclarations(decls)})
+}
+
+// parseStylesheet splits css into its rules and its at-rules, which are
+// returned as written.
+func parseStylesheet(css string) (rules []cssRule, atRules []string) {
+	css = stripComments(css)
+	for {
+		css = strings.TrimSpace(css)
+		if css == "" {
+			return rules, atRules
+		}
+
+		if css[0] == '@' {
+			end := cssIndex(css, ";{")
+			if end < 0 {
+				return rules, append(atRules, css)
+			}
+			if css[end] == '{' {
+				end = matchingBrace(css, end)
+			}
+			atRules = append(atRules, strings.TrimSpace(css[:end+1]))
+			css = css[end+1:]
+			continue
+		}
+
+		open := cssIndex(css, "{")
+		if open < 0 {
+			return rules, atRules
+		}
+		end := matchingBrace(css, open)
+		block := css[open+1:]
+		if css[end] == '}' {
+			block = css[open+1 : end]
+		}
+		rules = append(rules, cssRule{
+			selectors: strings.TrimSpace(css[:open]),
+			decls:     parseDeclarations(block),
+		})
+		css = css[end+1:]
+	}
+}
+
+// parseDeclarations parses a declaration block, e.g. a style attribute.
+func parseDeclarations(block string) []cssDeclaration {
+	var decls []cssDeclaration
+	for _, d := range splitOutside(block, ';') {
+		prop, value, ok := strings.Cut(d, ":")
+		prop, value = strings.ToLower(strings.TrimSpace(prop)), strings.TrimSpace(value)
+		if !ok || prop == "" || value == "" {
+			continue
+		}
+
+		important := false
+		if i := strings.LastIndexByte(value, '!'); i >= 0 &&
+			strings.EqualFold(strings.TrimSpace(value[i+1:]), "important") {
+			value, important = strings.TrimSpace(value[:i]), true
+		}
+		decls = append(decls, cssDeclaration{property: prop, value: value, important: important})
+	}
+	return decls
+}
+
+func formatDeclarations(decls []cssDeclaration) string {
+	parts := make([]string, len(decls))
+	for i, d := range decls {
+		parts[i] = d.property + ": " + d.value
+		if d.important {
+			parts[i] += " !important"
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+// splitSelectors splits a selector group at its top level commas.
+func splitSelectors(group string) []string {
+	var selectors []string
+	for _, s := range splitOutside(group, ',') {
+		if s = strings.TrimSpace(s); s != "" {
+			selectors = append(selectors, s)
+		}
+	}
+	return selectors
+}
+
+// dynamicPseudoClasses depend on user interaction, so can't be inlined.
+var dynamicPseudoClasses = []string{
+	":hover", ":active", ":focus", ":visited", ":link", ":target", ":checked",
+}
+
+func isDynamicSelector(selector string) bool {
+	lower := strings.ToLower(selector)
+	for _, p := range dynamicPseudoClasses {
+		if strings.Contains(lower, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitOutside splits s at sep, except within quotes and parentheses.
+func splitOutside(s string, sep byte) []string {
+	var (
+		parts []string
+		depth int
+		quote byte
+		start int
+	)
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(' || c == '[':
+			depth++
+		case c == ')' || c == ']':
+			depth = max(depth-1, 0)
+		case c == sep && depth == 0:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// cssIndex returns the index of the first of chars in css outside of
+// quotes, or -1.
+func cssIndex(css, chars string) int {
+	var quote byte
+	for i := 0; i < len(css); i++ {
+		switch c := css[i]; {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case strings.IndexByte(chars, c) >= 0:
+			return i
+		}
+	}
+	return -1
+}
+
+// matchingBrace returns the index of the brace closing the one at open, or
+// the end of css if it is not closed.
+func matchingBrace(css string, open int) int {
+	depth := 0
+	for i := open; i < len(css); {
+		j := cssIndex(css[i:], "{}")
+		if j < 0 {
+			break
+		}
+		i += j
+		if css[i] == '{' {
+			depth++
+		} else if depth--; depth == 0 {
+			return i
+		}
+		i++
+	}
+	return len(css) - 1
+}
+
+func stripComments(css string) string {
+	var b strings.Builder
+	for {
+		start := strings.Index(css, "/*")
+		if start < 0 {
+			b.WriteString(css)
+			return b.String()
+		}
+		b.WriteString(css[:start])
+		end := strings.Index(css[start+2:], "*/")
+		if end < 0 {
+			return b.String()
+		}
+		css = css[start+2+end+2:]
+	}
+}
+
+// walkNodes calls fn for n and its descendants, depth first, skipping the
+// descendants of nodes fn returns false for.
+func walkNodes(n *html.Node, fn func(*html.Node) bool) {
+	if !fn(n) {
+		return
+	}
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		walkNodes(c, fn)
+		c = next
+	}
+}
+
+// CSSInliner returns a Transformer running the HtmlBody of emails through
+// InlineCSS. onUnsupported, if not nil, is called with the selectors that
+// could not be inlined.
+func CSSInliner(onUnsupported func(selectors []string)) Transformer {
+	c := &cssInlining{onUnsupported: onUnsupported}
+	return func(_ context.Context, msg *OutgoingMessage) error {
+		return c.inline(&msg.HtmlBody)
+	}
+}
+
+type cssInlining struct {
+	onUnsupported func(selectors []string)
+}
+
+// UseCSSInlining adds a CSSInliner to the transformers of e.
+func (e *Email) UseCSSInlining(onUnsupported func(selectors []string)) {
+	e.UseTransformers(CSSInliner(onUnsupported))
+}
+
+// UseCSSInlining makes the HtmlBody of added and updated templates go
+// through InlineCSS. onUnsupported, if not nil, is called with the
+// selectors that could not be inlined.
+func (t *Template) UseCSSInlining(onUnsupported func(selectors []string)) {
+	t.cssInlining = &cssInlining{onUnsupported: onUnsupported}
+}
+
+// inline runs InlineCSS on htmlBody.
+func (c *cssInlining) inline(htmlBody *string) error {
+	if *htmlBody == "" {
+		return nil
+	}
+
+	body, unsupported, err := InlineCSS(*htmlBody)
+	if err != nil {
+		return fmt.Errorf("inlining CSS failed: %w", err)
+	}
+	*htmlBody = body
+	if len(unsupported) > 0 && c.onUnsupported != nil {
+		c.onUnsupported(unsupported)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package zeptomail_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/blancsoft/go-zeptomail"
+)
+
+func TestInlineCSS(t *testing.T) {
+	body := `<html><head><style>
+/* brand */
+p { color: #333; margin: 0 }
+.lead, h1 { font-size: 18px }
+#cta { color: white !important; background: {{brand_color}} }
+a:hover { color: red }
+@media (max-width: 600px) { .lead { font-size: 16px } }
+</style></head><body>
+<h1>Hello</h1>
+<p class="lead" style="color: black">Intro</p>
+<a id="cta" href="{{url}}" style="color: blue">Go</a>
+</body></html>`
+
+	got, unsupported, err := zeptomail.InlineCSS(body)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a:hover"}, unsupported)
+
+	assert.Contains(t, got, `<h1 style="font-size: 18px">Hello</h1>`)
+	assert.Contains(t, got, `<p class="lead" style="color: black; margin: 0; font-size: 18px">Intro</p>`,
+		"style attributes win over rules of any specificity")
+	assert.Contains(t, got, `<a id="cta" href="{{url}}" style="background: {{brand_color}}; color: white">Go</a>`,
+		"!important rules win over style attributes")
+	assert.Contains(t, got, "<head><style>\n@media (max-width: 600px) { .lead { font-size: 16px } }\na:hover { color: red }\n</style></head>")
+	assert.NotContains(t, got, "/*", "comments are dropped")
+
+	t.Run("merge tag sections in tables", func(t *testing.T) {
+		got, _, err := zeptomail.InlineCSS(`<style>td{color:red}</style><table>{{#items}}<tr><td>{{name}}</td></tr>{{/items}}</table>`)
+		require.NoError(t, err)
+		assert.Equal(t, `<table>{{#items}}<tr><td style="color: red">{{name}}</td></tr>{{/items}}</table>`, got)
+	})
+
+	t.Run("fragments stay fragments", func(t *testing.T) {
+		got, _, err := zeptomail.InlineCSS(`<style>p { margin: 0 } p:hover { color: red }</style><p>Hi<p>{{name}}<br>`)
+		require.NoError(t, err)
+		assert.Equal(t, "<style>\np:hover { color: red }\n</style>"+
+			`<p style="margin: 0">Hi<p style="margin: 0">{{name}}<br>`, got)
+	})
+
+	t.Run("no style blocks", func(t *testing.T) {
+		got, unsupported, err := zeptomail.InlineCSS(`<p>{{name}}</p>`)
+		require.NoError(t, err)
+		assert.Equal(t, `<p>{{name}}</p>`, got)
+		assert.Empty(t, unsupported)
+	})
+}
+
+func TestUseCSSInlining(t *testing.T) {
+	var sent map[string]any
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		require.NoError(t, json.Unmarshal(b, &sent))
+		if strings.Contains(r.URL.Path, "/templates/") {
+			_, _ = w.Write([]byte(`{"data":{"template_key":"tpl"},"message":"OK"}`))
+			return
+		}
+		acceptHandler(w, r)
+	})
+
+	var reported []string
+	email := (*zeptomail.Email)(client)
+	email.UseCSSInlining(func(selectors []string) { reported = append(reported, selectors...) })
+	_, err := email.SendBatchHTMLEmail(t.Context(), zeptomail.SendBatchHTMLEmailReq{
+		From:     sender,
+		To:       []zeptomail.SendBatchEmailTo{{EmailAddress: receiver, MergeInfo: map[string]any{}}},
+		Subject:  emailSubject,
+		HtmlBody: `<style>b { color: red } b::after { content: "!" }</style><b>Hi</b>`,
+	})
+	require.NoError(t, err)
+	assert.Contains(t, sent["htmlbody"], `<b style="color: red">Hi</b>`)
+	assert.Equal(t, []string{"b::after"}, reported)
+
+	template := (*zeptomail.Template)(client)
+	template.UseCSSInlining(nil)
+	_, err = template.UpdateEmailTemplate(t.Context(), zeptomail.UpdateEmailTemplateReq{
+		TemplateName: "welcome",
+		TemplateKey:  "tpl",
+		Subject:      emailSubject,
+		HtmlBody:     `<style>i { color: red }</style><i>Hi</i>`,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, `<i style="color: red">Hi</i>`, sent["htmlbody"], "templates are not made into documents")
+}
@@ -0,0 +1,399 @@
+package zeptomail
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DevInbox is a development transport for Email: it is an
+// http.RoundTripper that, instead of sending emails, writes each one into a
+// directory as an .eml file, with its merge tags rendered, as its
+// recipients would get it. It is also an http.Handler serving a small web
+// inbox to browse them.
+//
+//	inbox, err := zeptomail.NewDevInbox("tmp/mail")
+//	client, err := zeptomail.NewClient(agent, token, &http.Client{Transport: inbox})
+//	go http.ListenAndServe("localhost:8025", inbox)
+//
+// Batch emails are written as one file per recipient. Templated emails are
+// rendered with the templates given to AddTemplate; those of other
+// templates list their merge info instead. API calls other than sends
+// fail.
+type DevInbox struct {
+	dir string
+
+	mu        sync.RWMutex
+	templates map[string]MergeContent
+}
+
+// NewDevInbox returns a DevInbox writing to dir, which is created if need
+// be.
+func NewDevInbox(dir string) (*DevInbox, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &DevInbox{dir: dir, templates: make(map[string]MergeContent)}, nil
+}
+
+// AddTemplate makes templated emails sent with the key, or alias, of a
+// template render content.
+func (d *DevInbox) AddTemplate(key string, content MergeContent) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.templates[key] = content
+}
+
+// template returns the content of the template key, or content listing
+// the merge info if it was not added.
+func (d *DevInbox) template(key string) *MergeContent {
+	d.mu.RLock()
+	c, ok := d.templates[key]
+	d.mu.RUnlock()
+	if ok {
+		return &c
+	}
+	return &MergeContent{
+		Subject:  "Template " + key,
+		TextBody: "Template " + key + " rendered with the merge info:\n\n{{" + devInboxMergeInfoTag + "}}\n",
+	}
+}
+
+// devInboxMergeInfoTag is set, in the merge info of emails of unknown
+// templates, to the merge info as JSON.
+const devInboxMergeInfoTag = "zeptomail_merge_info"
+
+// RoundTrip implements http.RoundTripper.
+func (d *DevInbox) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		defer func() { _ = req.Body.Close() }()
+	}
+	if req.Method != http.MethodPost {
+		return devInboxResponse(req, http.StatusNotFound, "not supported by DevInbox")
+	}
+
+	var payload any
+	switch path := strings.TrimSuffix(req.URL.Path, "/"); {
+	case strings.HasSuffix(path, "/email/template/batch"):
+		payload = &SendBatchTemplatedEmailReq{}
+	case strings.HasSuffix(path, "/email/template"):
+		payload = &SendTemplatedEmailReq{}
+	case strings.HasSuffix(path, "/email/batch"):
+		payload = &SendBatchHTMLEmailReq{}
+	case strings.HasSuffix(path, "/email"):
+		payload = &SendHTMLEmailReq{}
+	default:
+		return devInboxResponse(req, http.StatusNotFound, "not supported by DevInbox")
+	}
+	if err := json.NewDecoder(req.Body).Decode(payload); err != nil {
+		return devInboxResponse(req, http.StatusBadRequest, err.Error())
+	}
+
+	msg := newOutgoingMessage(payload)
+	var content *MergeContent
+	if msg.Kind == TemplatedMessage || msg.Kind == BatchTemplatedMessage {
+		content = d.template(msg.TemplateKey)
+		if strings.Contains(content.TextBody, devInboxMergeInfoTag) {
+			addMergeInfoJSON(msg)
+		}
+	}
+	messages, err := mimeMessages(msg, content)
+	if err != nil {
+		return devInboxResponse(req, http.StatusBadRequest, err.Error())
+	}
+
+	requestID := "devinbox-" + randomHex(8)
+	now := time.Now()
+	for i, m := range messages {
+		m.Date = now
+		var buf bytes.Buffer
+		if _, err = m.WriteTo(&buf); err != nil {
+			return devInboxResponse(req, http.StatusBadRequest, err.Error())
+		}
+		name := fmt.Sprintf("%s-%s-%d.eml", now.UTC().Format("20060102T150405.000000000"), requestID, i+1)
+		if err = writeFileAtomic(filepath.Join(d.dir, name), buf.Bytes()); err != nil {
+			return nil, err
+		}
+	}
+
+	res, err := json.Marshal(SendHTMLEmailRes{
+		Data:      []SendEmailResData{{Code: "EM_104", AdditionalInfo: []any{}, Message: "Email request received"}},
+		Message:   "OK",
+		RequestId: requestID,
+		Object:    "email",
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newJSONResponse(req, http.StatusCreated, res), nil
+}
+
+// addMergeInfoJSON sets devInboxMergeInfoTag in the merge info of msg.
+func addMergeInfoJSON(msg *OutgoingMessage) {
+	encode := func(mergeInfo map[string]any) map[string]any {
+		b, _ := json.MarshalIndent(mergeInfo, "", "  ")
+		rv := cloneMergeInfo(mergeInfo)
+		rv[devInboxMergeInfoTag] = string(b)
+		return rv
+	}
+	msg.MergeInfo = encode(msg.MergeInfo)
+	for i := range msg.To {
+		msg.To[i].MergeInfo = encode(msg.To[i].MergeInfo)
+	}
+}
+
+func devInboxResponse(req *http.Request, status int, message string) (*http.Response, error) {
+	res, err := json.Marshal(map[string]any{
+		"error": map[string]any{"code": "DEVINBOX", "message": message},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newJSONResponse(req, status, res), nil
+}
+
+func newJSONResponse(req *http.Request, status int, body []byte) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// devInboxEmail is an .eml file of a DevInbox, parsed for the web inbox.
+type devInboxEmail struct {
+	ID      string
+	Header  mail.Header
+	Date    time.Time
+	Subject string
+	HTML    string
+	Text    string
+	Parts   []devInboxPart
+}
+
+type devInboxPart struct {
+	Index       int
+	ContentType string
+	Filename    string
+	ContentID   string
+	Attachment  bool
+	Body        []byte
+}
+
+// Emails returns the IDs of the emails in the inbox, newest first.
+func (d *DevInbox) Emails() ([]string, error) {
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".eml") {
+			ids = append(ids, e.Name())
+		}
+	}
+	slices.Sort(ids)
+	slices.Reverse(ids)
+	return ids, nil
+}
+
+// open reads and parses the email id.
+func (d *DevInbox) open(id string) (*devInboxEmail, error) {
+	if id != filepath.Base(id) || !strings.HasSuffix(id, ".eml") {
+		return nil, os.ErrNotExist
+	}
+	f, err := os.Open(filepath.Join(d.dir, id))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	msg, err := mail.ReadMessage(f)
+	if err != nil {
+		return nil, err
+	}
+	e := &devInboxEmail{ID: id, Header: msg.Header}
+	e.Date, _ = msg.Header.Date()
+	e.Subject, _ = new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err = e.walk(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), "", "", msg.Body); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// walk collects the leaf parts of the body.
+func (e *devInboxEmail) walk(contentType, encoding, disposition, contentID string, body io.Reader) error {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "text/plain"
+	}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			p, err := mr.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err = e.walk(p.Header.Get("Content-Type"), p.Header.Get("Content-Transfer-Encoding"),
+				p.Header.Get("Content-Disposition"), p.Header.Get("Content-Id"), p); err != nil {
+				return err
+			}
+		}
+	}
+
+	switch strings.ToLower(encoding) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+	b, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+
+	dispType, dispParams, _ := mime.ParseMediaType(disposition)
+	part := devInboxPart{
+		Index:       len(e.Parts),
+		ContentType: mediaType,
+		Filename:    dispParams["filename"],
+		ContentID:   strings.Trim(contentID, "<>"),
+		Attachment:  dispType == "attachment",
+		Body:        b,
+	}
+	if part.Filename == "" {
+		part.Filename = params["name"]
+	}
+	switch {
+	case mediaType == "text/html" && !part.Attachment && e.HTML == "":
+		e.HTML = string(b)
+	case mediaType == "text/plain" && !part.Attachment && e.Text == "":
+		e.Text = string(b)
+	default:
+		e.Parts = append(e.Parts, part)
+	}
+	return nil
+}
+
+var devInboxPage = template.Must(template.New("inbox").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>{{if .Email}}{{.Email.Subject}}{{else}}Inbox{{end}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; width: 100%; }
+td, th { text-align: left; padding: .3em .6em; border-bottom: 1px solid #ddd; }
+iframe { width: 100%; height: 70vh; border: 1px solid #ddd; }
+pre { white-space: pre-wrap; }
+</style></head>
+<body>{{if .Email}}{{with .Email}}
+<p><a href="?">Inbox</a> · <a href="?id={{.ID}}&amp;raw=1">Raw</a></p>
+<h1>{{.Subject}}</h1>
+<table>{{range $name := $.Headers}}{{with $.Email.Header.Get $name}}<tr><th>{{$name}}</th><td>{{.}}</td></tr>{{end}}{{end}}</table>
+{{if .Parts}}<p>Parts:{{range .Parts}} <a href="?id={{$.Email.ID}}&amp;part={{.Index}}">{{if .Filename}}{{.Filename}}{{else}}{{.ContentID}}{{end}}</a> ({{.ContentType}}){{end}}</p>{{end}}
+{{if .HTML}}<iframe sandbox="allow-popups allow-popups-to-escape-sandbox" srcdoc="{{$.HTML}}"></iframe>{{end}}
+{{if .Text}}<pre>{{.Text}}</pre>{{end}}
+{{end}}{{else}}
+<h1>Inbox</h1>
+<table><tr><th>Date</th><th>From</th><th>To</th><th>Subject</th></tr>
+{{range .Emails}}<tr><td>{{.Date.Format "2006-01-02 15:04:05"}}</td><td>{{.Header.Get "From"}}</td><td>{{.Header.Get "To"}}</td>
+<td><a href="?id={{.ID}}">{{.Subject}}</a></td></tr>
+{{else}}<tr><td colspan="4">No emails yet.</td></tr>{{end}}</table>
+{{end}}</body></html>
+`))
+
+// ServeHTTP serves the web inbox: the list of emails, and each email with
+// its HTML and text bodies, headers, attachments and raw source.
+func (d *DevInbox) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	id := q.Get("id")
+	if id == "" {
+		d.serveList(w)
+		return
+	}
+
+	e, err := d.open(id)
+	if os.IsNotExist(err) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	switch {
+	case q.Has("raw"):
+		w.Header().Set("Content-Type", "message/rfc822")
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": id}))
+		http.ServeFile(w, r, filepath.Join(d.dir, id))
+	case q.Has("part") || q.Has("cid"):
+		for _, p := range e.Parts {
+			if (q.Has("part") && fmt.Sprint(p.Index) == q.Get("part")) || (q.Has("cid") && p.ContentID == q.Get("cid")) {
+				w.Header().Set("Content-Type", p.ContentType)
+				if p.Attachment {
+					w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": p.Filename}))
+				}
+				_, _ = w.Write(p.Body)
+				return
+			}
+		}
+		http.NotFound(w, r)
+	default:
+		// Inline images are served by the inbox; links open in a new tab, which
+		// the sandbox of the frame allows.
+		body := strings.ReplaceAll(e.HTML, `"cid:`, `"?id=`+url.QueryEscape(id)+`&cid=`)
+		if body != "" && !strings.Contains(strings.ToLower(body), "<base") {
+			body = `<base target="_blank">` + body
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = devInboxPage.Execute(w, map[string]any{
+			"Email":   e,
+			"HTML":    body,
+			"Headers": []string{"Date", "From", "Reply-To", "To", "Cc", "Bcc"},
+		})
+	}
+}
+
+func (d *DevInbox) serveList(w http.ResponseWriter) {
+	ids, err := d.Emails()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	emails := make([]*devInboxEmail, 0, len(ids))
+	for _, id := range ids {
+		if e, err := d.open(id); err == nil {
+			emails = append(emails, e)
+		}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_ = devInboxPage.Execute(w, map[string]any{"Emails": emails})
+}
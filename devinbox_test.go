@@ -0,0 +1,128 @@
+package zeptomail_test
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/blancsoft/go-zeptomail"
+)
+
+func TestDevInbox(t *testing.T) {
+	dir := t.TempDir()
+	inbox, err := zeptomail.NewDevInbox(dir)
+	require.NoError(t, err)
+	inbox.AddTemplate("welcome", zeptomail.MergeContent{Subject: "Welcome {{name}}", TextBody: "Hi {{name}}"})
+
+	client, err := zeptomail.NewClient("test-agent", "Zoho-enczapikey test", &http.Client{Transport: inbox})
+	require.NoError(t, err)
+	email := (*zeptomail.Email)(client)
+
+	res, err := email.SendHTMLEmail(t.Context(), zeptomail.SendHTMLEmailReq{
+		BaseSendEmail: zeptomail.BaseSendEmail{
+			From:      sender,
+			To:        []zeptomail.SendEmailTo{{EmailAddress: receiver}},
+			MergeInfo: map[string]any{"name": "Ada"},
+		},
+		BaseEmailOption: zeptomail.BaseEmailOption{
+			Attachments: []zeptomail.EmailAttachment{{
+				Name:     "note.txt",
+				MimeType: "text/plain",
+				Content:  base64.StdEncoding.EncodeToString([]byte("attached")),
+			}},
+			InlineImages: []zeptomail.InlineImage{{
+				Cid:      "logo",
+				MimeType: "image/x-icon",
+				Content:  base64.StdEncoding.EncodeToString(fileAttachment),
+			}},
+		},
+		Subject:  "Hello {{name}}",
+		HtmlBody: `<p>Hello {{name}}</p><img src="cid:logo">`,
+	})
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(res.Data.RequestId, "devinbox-"))
+
+	_, err = email.SendBatchHTMLEmail(t.Context(), zeptomail.SendBatchHTMLEmailReq{
+		From: sender,
+		To: []zeptomail.SendBatchEmailTo{
+			{EmailAddress: receiver, MergeInfo: map[string]any{"name": "Ada"}},
+			{EmailAddress: other, MergeInfo: map[string]any{"name": "Bob"}},
+		},
+		Subject:  "Batch for {{name}}",
+		TextBody: "Hi {{name}}",
+	})
+	require.NoError(t, err)
+
+	_, err = email.SendTemplatedEmail(t.Context(), zeptomail.SendTemplatedEmailReq{
+		BaseSendEmail: zeptomail.BaseSendEmail{
+			From:      sender,
+			To:        []zeptomail.SendEmailTo{{EmailAddress: receiver}},
+			MergeInfo: map[string]any{"name": "Ada"},
+		},
+		TemplateKey: "welcome",
+	})
+	require.NoError(t, err)
+
+	ids, err := inbox.Emails()
+	require.NoError(t, err)
+	require.Len(t, ids, 4, "batch emails are written once per recipient")
+
+	subjects := make(map[string]*mail.Message)
+	for _, id := range ids {
+		f, err := os.Open(filepath.Join(dir, id))
+		require.NoError(t, err)
+		msg, err := mail.ReadMessage(f)
+		require.NoError(t, err)
+		subjects[msg.Header.Get("Subject")] = msg
+		_ = f.Close()
+	}
+	assert.Contains(t, subjects, "Batch for Ada")
+	assert.Contains(t, subjects, "Batch for Bob")
+	assert.Contains(t, subjects, "Welcome Ada")
+	require.Contains(t, subjects, "Hello Ada")
+	assert.Contains(t, subjects["Hello Ada"].Header.Get("Content-Type"), "multipart/mixed")
+
+	t.Run("web inbox", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		inbox.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		for _, s := range []string{"Hello Ada", "Batch for Bob", "Welcome Ada"} {
+			assert.Contains(t, rec.Body.String(), s)
+		}
+
+		var id string
+		for _, i := range ids {
+			b, err := os.ReadFile(filepath.Join(dir, i))
+			require.NoError(t, err)
+			if strings.Contains(string(b), "Subject: Hello Ada") {
+				id = i
+			}
+		}
+		require.NotEmpty(t, id)
+
+		rec = httptest.NewRecorder()
+		inbox.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?id="+id, nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `<iframe sandbox="allow-popups allow-popups-to-escape-sandbox" srcdoc=`,
+			"links open in a new tab, outside the sandbox")
+		assert.Contains(t, rec.Body.String(), "cid=logo", "inline images are served by the inbox")
+		assert.Contains(t, rec.Body.String(), "note.txt")
+
+		rec = httptest.NewRecorder()
+		inbox.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?id="+id+"&cid=logo", nil))
+		assert.Equal(t, "image/x-icon", rec.Header().Get("Content-Type"))
+		assert.Equal(t, fileAttachment, rec.Body.Bytes())
+
+		rec = httptest.NewRecorder()
+		inbox.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?id=../secret.eml", nil))
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
@@ -0,0 +1,515 @@
+package zeptomail
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+)
+
+type (
+	// DigestEvent is a notification waiting to be sent to its recipient as
+	// part of a digest.
+	DigestEvent struct {
+		// ID identifies the event in a DigestStore. It is generated by
+		// Digester.Add when empty.
+		ID string `json:"id"`
+
+		// Recipient the event is addressed to.
+		Recipient EmailAddress `json:"recipient" validate:"required"`
+
+		// Key of the template the digest is sent with.
+		TemplateKey string `json:"template_key" validate:"required"`
+
+		// Merge info of this event; it becomes one item of the digest list.
+		Data map[string]any `json:"data"`
+
+		// Time the event was added. Set by Digester.Add when zero.
+		CreatedAt time.Time `json:"created_at"`
+	}
+
+	// DigestStore persists the events a Digester has not sent yet, so they
+	// survive a restart of the process.
+	DigestStore interface {
+		Save(ctx context.Context, event DigestEvent) error
+		Delete(ctx context.Context, ids ...string) error
+		// Load returns all the pending events.
+		Load(ctx context.Context) ([]DigestEvent, error)
+	}
+
+	// DigestConfig configures a Digester.
+	DigestConfig struct {
+		// Sender of the digests.
+		From EmailAddress `validate:"required"`
+
+		// Window is how long a digest waits for further events after the
+		// last one before being sent.
+		Window time.Duration `validate:"gt=0"`
+
+		// MaxDelay bounds how long the first event of a digest may wait,
+		// however often new events arrive. Zero means no bound.
+		MaxDelay time.Duration
+
+		// MaxSize sends a digest as soon as it holds that many events.
+		// Zero means no limit.
+		MaxSize int
+
+		// ListKey is the merge info key holding the list of event data.
+		// Defaults to "events". The number of events is set under
+		// ListKey + "_count".
+		ListKey string
+
+		// Prepare, if set, is called with each digest request before it is
+		// sent, e.g. to add a bounce address or more merge info.
+		Prepare func(req *SendTemplatedEmailReq, events []DigestEvent)
+
+		// MaxAttempts is how many times a failing digest is sent before it
+		// is dropped. Retries back off from Window, doubling each time.
+		// Defaults to 5.
+		MaxAttempts int `validate:"gte=0"`
+
+		// OnError receives the errors of digests sent in the background,
+		// and a *DigestDroppedError for every digest given up on.
+		OnError func(err error)
+	}
+
+	// DigestDroppedError reports a digest that was given up on, either
+	// because it failed MaxAttempts times or because sending it again
+	// can't succeed, e.g. it was rejected by the API. Its events are
+	// deleted from the store.
+	DigestDroppedError struct {
+		Events []DigestEvent
+		Err    error
+	}
+)
+
+func (e *DigestDroppedError) Error() string {
+	return fmt.Sprintf("dropped digest of %d events: %v", len(e.Events), e.Err)
+}
+
+func (e *DigestDroppedError) Unwrap() error {
+	return e.Err
+}
+
+// Digester coalesces the events sent to a recipient with the same template
+// into a single SendTemplatedEmail whose merge info lists them all.
+type Digester struct {
+	email *Email
+	store DigestStore
+	cfg   DigestConfig
+
+	mu      sync.Mutex
+	batches map[string]*digestBatch
+	closed  bool
+}
+
+type digestBatch struct {
+	events   []DigestEvent
+	sending  bool
+	attempts int
+	timer    *time.Timer
+}
+
+// NewDigester returns a Digester sending through email and keeping pending
+// events in store. Call Start to resume the events persisted by a previous
+// process.
+func NewDigester(email *Email, store DigestStore, cfg DigestConfig) (*Digester, error) {
+	if err := validate.Struct(&cfg); err != nil {
+		return nil, err
+	}
+	if cfg.ListKey == "" {
+		cfg.ListKey = "events"
+	}
+	if cfg.MaxAttempts == 0 {
+		cfg.MaxAttempts = 5
+	}
+	return &Digester{
+		email:   email,
+		store:   store,
+		cfg:     cfg,
+		batches: make(map[string]*digestBatch),
+	}, nil
+}
+
+func digestKey(recipient EmailAddress, templateKey string) string {
+	return strings.ToLower(recipient.Address) + "\x00" + templateKey
+}
+
+// Start schedules the events persisted in the store.
+func (d *Digester) Start(ctx context.Context) error {
+	events, err := d.store.Load(ctx)
+	if err != nil {
+		return fmt.Errorf("loading digest events failed: %w", err)
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].CreatedAt.Before(events[j].CreatedAt) })
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, event := range events {
+		key := digestKey(event.Recipient, event.TemplateKey)
+		b := d.batch(key)
+		b.events = append(b.events, event)
+		d.schedule(key, b)
+	}
+	return nil
+}
+
+// Add queues event for the digest of its recipient and template. The digest
+// is sent right away, in the calling goroutine, once it reaches MaxSize, or
+// as soon as the send in flight for it is done.
+func (d *Digester) Add(ctx context.Context, event DigestEvent) error {
+	if event.ID == "" {
+		event.ID = rand.Text()
+	}
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+	if err := validate.Struct(&event); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	closed := d.closed
+	d.mu.Unlock()
+	if closed {
+		return errors.New("digester is closed")
+	}
+	if err := d.store.Save(ctx, event); err != nil {
+		return fmt.Errorf("saving digest event failed: %w", err)
+	}
+
+	d.mu.Lock()
+	if d.closed {
+		// the event is in the store, for the next Start
+		d.mu.Unlock()
+		return nil
+	}
+	key := digestKey(event.Recipient, event.TemplateKey)
+	b := d.batch(key)
+	b.events = append(b.events, event)
+	if d.cfg.MaxSize > 0 && len(b.events) >= d.cfg.MaxSize && !b.sending {
+		d.mu.Unlock()
+		return d.flush(ctx, key)
+	}
+	d.schedule(key, b)
+	d.mu.Unlock()
+	return nil
+}
+
+// Flush sends every pending digest now.
+func (d *Digester) Flush(ctx context.Context) error {
+	d.mu.Lock()
+	keys := make([]string, 0, len(d.batches))
+	for key := range d.batches {
+		keys = append(keys, key)
+	}
+	d.mu.Unlock()
+
+	var errs []error
+	for _, key := range keys {
+		errs = append(errs, d.flush(ctx, key))
+	}
+	return errors.Join(errs...)
+}
+
+// Close stops the timers of the pending digests without sending them. Their
+// events stay in the store for the next Start.
+func (d *Digester) Close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.closed = true
+	for _, b := range d.batches {
+		if b.timer != nil {
+			b.timer.Stop()
+		}
+	}
+}
+
+// batch returns the batch for key, creating it. d.mu must be held.
+func (d *Digester) batch(key string) *digestBatch {
+	b, ok := d.batches[key]
+	if !ok {
+		b = &digestBatch{}
+		d.batches[key] = b
+	}
+	return b
+}
+
+// schedule (re)arms the timer of b. d.mu must be held.
+func (d *Digester) schedule(key string, b *digestBatch) {
+	if d.closed || b.sending || len(b.events) == 0 {
+		return
+	}
+
+	at := b.events[len(b.events)-1].CreatedAt.Add(d.cfg.Window)
+	if d.cfg.MaxDelay > 0 {
+		if deadline := b.events[0].CreatedAt.Add(d.cfg.MaxDelay); deadline.Before(at) {
+			at = deadline
+		}
+	}
+
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	b.timer = time.AfterFunc(time.Until(at), func() {
+		if err := d.flush(context.Background(), key); err != nil && d.cfg.OnError != nil {
+			d.cfg.OnError(err)
+		}
+	})
+}
+
+// flush sends the digest for key. Events added while it is being sent are
+// left for the next digest, which is sent right away if they reach MaxSize.
+func (d *Digester) flush(ctx context.Context, key string) error {
+	d.mu.Lock()
+	b, ok := d.batches[key]
+	if !ok || b.sending || len(b.events) == 0 {
+		d.mu.Unlock()
+		return nil
+	}
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	b.sending = true
+	events := b.events
+	b.events = nil
+	d.mu.Unlock()
+
+	err := d.send(ctx, events)
+	var dropped *DigestDroppedError
+
+	d.mu.Lock()
+	b.sending = false
+	if err != nil {
+		b.attempts++
+		if errors.As(err, &dropped) || b.attempts >= d.cfg.MaxAttempts {
+			if dropped == nil {
+				dropped = &DigestDroppedError{Events: events, Err: err}
+			}
+			b.attempts = 0
+		} else {
+			b.events = append(events, b.events...)
+		}
+	} else {
+		b.attempts = 0
+	}
+
+	switch {
+	case len(b.events) == 0:
+		delete(d.batches, key)
+	case err != nil && dropped == nil:
+		if !d.closed {
+			// back off from a full window rather than retrying immediately
+			b.timer = time.AfterFunc(d.cfg.Window<<min(b.attempts-1, 5), func() {
+				if err := d.flush(context.Background(), key); err != nil && d.cfg.OnError != nil {
+					d.cfg.OnError(err)
+				}
+			})
+		}
+	case d.cfg.MaxSize > 0 && len(b.events) >= d.cfg.MaxSize && !d.closed:
+		d.mu.Unlock()
+		return errors.Join(d.drop(ctx, dropped), d.flush(ctx, key))
+	default:
+		d.schedule(key, b)
+	}
+	d.mu.Unlock()
+
+	if dropped != nil {
+		return d.drop(ctx, dropped)
+	}
+	return err
+}
+
+// drop deletes the events of a digest given up on, returning dropped.
+func (d *Digester) drop(ctx context.Context, dropped *DigestDroppedError) error {
+	if dropped == nil {
+		return nil
+	}
+	ids := make([]string, 0, len(dropped.Events))
+	for _, event := range dropped.Events {
+		ids = append(ids, event.ID)
+	}
+	if err := d.store.Delete(ctx, ids...); err != nil {
+		return errors.Join(dropped, fmt.Errorf("deleting digest events failed: %w", err))
+	}
+	return dropped
+}
+
+func (d *Digester) send(ctx context.Context, events []DigestEvent) error {
+	list := make([]map[string]any, 0, len(events))
+	for _, event := range events {
+		list = append(list, event.Data)
+	}
+
+	first := events[0]
+	req := SendTemplatedEmailReq{
+		BaseSendEmail: BaseSendEmail{
+			From: d.cfg.From,
+			To:   []SendEmailTo{{EmailAddress: first.Recipient}},
+			MergeInfo: map[string]any{
+				d.cfg.ListKey:            list,
+				d.cfg.ListKey + "_count": len(events),
+			},
+		},
+		// a digest that was sent before a crash keeps its reference, which
+		// lets Email.UseIdempotency catch the resend
+		BaseEmailOption: BaseEmailOption{ClientReference: "digest-" + first.ID},
+		TemplateKey:     first.TemplateKey,
+	}
+	if d.cfg.Prepare != nil {
+		d.cfg.Prepare(&req, events)
+	}
+
+	rv, err := d.email.SendTemplatedEmail(ctx, req)
+	if err != nil {
+		err = fmt.Errorf("sending digest to %s failed: %w", first.Recipient.Address, err)
+		if permanentSendError(err) {
+			return &DigestDroppedError{Events: events, Err: err}
+		}
+		return err
+	}
+	if rv.Data.Error != nil {
+		err = fmt.Errorf("sending digest to %s failed: %s", first.Recipient.Address, rv.Data.Error.Message)
+		if status := rv.RawResponse.StatusCode; status >= 400 && status < 500 &&
+			status != http.StatusRequestTimeout && status != http.StatusTooManyRequests {
+			return &DigestDroppedError{Events: events, Err: err}
+		}
+		return err
+	}
+
+	ids := make([]string, 0, len(events))
+	for _, event := range events {
+		ids = append(ids, event.ID)
+	}
+	if err = d.store.Delete(ctx, ids...); err != nil {
+		return fmt.Errorf("deleting digest events failed: %w", err)
+	}
+	return nil
+}
+
+// MemoryDigestStore is a DigestStore that does not persist anything; pending
+// events are lost when the process exits.
+type MemoryDigestStore struct {
+	mu     sync.Mutex
+	events map[string]DigestEvent
+}
+
+// NewMemoryDigestStore returns an empty MemoryDigestStore.
+func NewMemoryDigestStore() *MemoryDigestStore {
+	return &MemoryDigestStore{events: make(map[string]DigestEvent)}
+}
+
+// Save implements DigestStore.
+func (s *MemoryDigestStore) Save(_ context.Context, event DigestEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events[event.ID] = event
+	return nil
+}
+
+// Delete implements DigestStore.
+func (s *MemoryDigestStore) Delete(_ context.Context, ids ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range ids {
+		delete(s.events, id)
+	}
+	return nil
+}
+
+// Load implements DigestStore.
+func (s *MemoryDigestStore) Load(context.Context) ([]DigestEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	events := make([]DigestEvent, 0, len(s.events))
+	for _, event := range s.events {
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// FileDigestStore is a DigestStore keeping each pending event as a JSON
+// file in a directory.
+type FileDigestStore struct {
+	dir string
+}
+
+// NewFileDigestStore returns a FileDigestStore writing to dir, creating it
+// if needed.
+func NewFileDigestStore(dir string) (*FileDigestStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &FileDigestStore{dir: dir}, nil
+}
+
+func (s *FileDigestStore) path(id string) string {
+	return filepath.Join(s.dir, filepath.Base(id)+".json")
+}
+
+// Save implements DigestStore.
+func (s *FileDigestStore) Save(_ context.Context, event DigestEvent) error {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path(event.ID), b)
+}
+
+// Delete implements DigestStore.
+func (s *FileDigestStore) Delete(_ context.Context, ids ...string) error {
+	for _, id := range ids {
+		if err := os.Remove(s.path(id)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Load implements DigestStore.
+func (s *FileDigestStore) Load(context.Context) ([]DigestEvent, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]DigestEvent, 0, len(paths))
+	for _, p := range paths {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		var event DigestEvent
+		if err = json.Unmarshal(b, &event); err != nil {
+			return nil, fmt.Errorf("decoding %s failed: %w", p, err)
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// permanentSendError reports whether err rejected a send before it reached
+// the API, so that sending the same request again fails the same way.
+func permanentSendError(err error) bool {
+	var (
+		invalid    validator.ValidationErrors
+		header     *HeaderError
+		mergeTag   *MergeTagError
+		veto       *VetoError
+		suppressed *SuppressedError
+	)
+	return errors.As(err, &invalid) || errors.As(err, &header) || errors.As(err, &mergeTag) ||
+		errors.As(err, &veto) || errors.As(err, &suppressed)
+}
@@ -0,0 +1,212 @@
+package zeptomail_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/blancsoft/go-zeptomail"
+)
+
+// digestRecorder collects the templated send requests it receives.
+type digestRecorder struct {
+	mu   sync.Mutex
+	reqs []zeptomail.SendTemplatedEmailReq
+}
+
+func (d *digestRecorder) handle(w http.ResponseWriter, r *http.Request) {
+	var req zeptomail.SendTemplatedEmailReq
+	_ = json.NewDecoder(r.Body).Decode(&req)
+	d.mu.Lock()
+	d.reqs = append(d.reqs, req)
+	d.mu.Unlock()
+	acceptHandler(w, r)
+}
+
+func (d *digestRecorder) sent() []zeptomail.SendTemplatedEmailReq {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]zeptomail.SendTemplatedEmailReq(nil), d.reqs...)
+}
+
+func TestDigester(t *testing.T) {
+	event := func(recipient zeptomail.EmailAddress, n int) zeptomail.DigestEvent {
+		return zeptomail.DigestEvent{Recipient: recipient, TemplateKey: "activity", Data: map[string]any{"n": n}}
+	}
+
+	t.Run("coalesces per recipient within window", func(t *testing.T) {
+		rec := &digestRecorder{}
+		email := (*zeptomail.Email)(newTestClient(t, rec.handle))
+		digester, err := zeptomail.NewDigester(email, zeptomail.NewMemoryDigestStore(), zeptomail.DigestConfig{
+			From:   sender,
+			Window: 50 * time.Millisecond,
+		})
+		require.NoError(t, err)
+		defer digester.Close()
+
+		for i := range 3 {
+			require.NoError(t, digester.Add(t.Context(), event(receiver, i)))
+		}
+		require.NoError(t, digester.Add(t.Context(), event(other, 0)))
+
+		require.Eventually(t, func() bool { return len(rec.sent()) == 2 }, time.Second, 10*time.Millisecond)
+		counts := map[string]float64{}
+		for _, req := range rec.sent() {
+			assert.Equal(t, "activity", req.TemplateKey)
+			assert.Len(t, req.MergeInfo["events"], int(req.MergeInfo["events_count"].(float64)))
+			counts[req.To[0].EmailAddress.Address] = req.MergeInfo["events_count"].(float64)
+		}
+		assert.Equal(t, map[string]float64{receiver.Address: 3, other.Address: 1}, counts)
+	})
+
+	t.Run("max size sends immediately", func(t *testing.T) {
+		rec := &digestRecorder{}
+		email := (*zeptomail.Email)(newTestClient(t, rec.handle))
+		digester, err := zeptomail.NewDigester(email, zeptomail.NewMemoryDigestStore(), zeptomail.DigestConfig{
+			From:    sender,
+			Window:  time.Hour,
+			MaxSize: 2,
+		})
+		require.NoError(t, err)
+		defer digester.Close()
+
+		require.NoError(t, digester.Add(t.Context(), event(receiver, 1)))
+		assert.Empty(t, rec.sent())
+		require.NoError(t, digester.Add(t.Context(), event(receiver, 2)))
+		assert.Len(t, rec.sent(), 1)
+	})
+
+	t.Run("max size sends once the digest in flight is done", func(t *testing.T) {
+		rec := &digestRecorder{}
+		release := make(chan struct{})
+		email := (*zeptomail.Email)(newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			if len(rec.sent()) == 0 {
+				<-release
+			}
+			rec.handle(w, r)
+		}))
+		digester, err := zeptomail.NewDigester(email, zeptomail.NewMemoryDigestStore(), zeptomail.DigestConfig{
+			From:    sender,
+			Window:  time.Hour,
+			MaxSize: 1,
+		})
+		require.NoError(t, err)
+		defer digester.Close()
+
+		done := make(chan error)
+		go func() { done <- digester.Add(t.Context(), event(receiver, 1)) }()
+		time.Sleep(20 * time.Millisecond)
+		require.NoError(t, digester.Add(t.Context(), event(receiver, 2)))
+		close(release)
+		require.NoError(t, <-done)
+		assert.Len(t, rec.sent(), 2)
+	})
+
+	t.Run("rejected digests are dropped", func(t *testing.T) {
+		var calls atomic.Int32
+		email := (*zeptomail.Email)(newTestClient(t, func(w http.ResponseWriter, _ *http.Request) {
+			calls.Add(1)
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":{"code":"TM_3301","message":"Invalid template"}}`))
+		}))
+		store := zeptomail.NewMemoryDigestStore()
+		digester, err := zeptomail.NewDigester(email, store, zeptomail.DigestConfig{
+			From:    sender,
+			Window:  time.Hour,
+			MaxSize: 1,
+		})
+		require.NoError(t, err)
+		defer digester.Close()
+
+		err = digester.Add(t.Context(), event(receiver, 1))
+		var dropped *zeptomail.DigestDroppedError
+		require.ErrorAs(t, err, &dropped)
+		assert.Len(t, dropped.Events, 1)
+		assert.EqualValues(t, 1, calls.Load())
+		pending, err := store.Load(t.Context())
+		require.NoError(t, err)
+		assert.Empty(t, pending)
+	})
+
+	t.Run("failing digests are retried up to max attempts", func(t *testing.T) {
+		var calls atomic.Int32
+		email := (*zeptomail.Email)(newTestClient(t, func(w http.ResponseWriter, _ *http.Request) {
+			calls.Add(1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(`{"error":{"code":"GE_102","message":"Unavailable"}}`))
+		}))
+		errs := make(chan error, 10)
+		digester, err := zeptomail.NewDigester(email, zeptomail.NewMemoryDigestStore(), zeptomail.DigestConfig{
+			From:        sender,
+			Window:      5 * time.Millisecond,
+			MaxAttempts: 3,
+			OnError:     func(err error) { errs <- err },
+		})
+		require.NoError(t, err)
+		defer digester.Close()
+
+		require.NoError(t, digester.Add(t.Context(), event(receiver, 1)))
+		var dropped *zeptomail.DigestDroppedError
+		for range 3 {
+			select {
+			case err := <-errs:
+				dropped = nil
+				errors.As(err, &dropped)
+			case <-time.After(time.Second):
+				t.Fatal("digest was not retried")
+			}
+		}
+		require.NotNil(t, dropped, "the last attempt drops the digest")
+		time.Sleep(50 * time.Millisecond)
+		assert.EqualValues(t, 3, calls.Load())
+	})
+
+	t.Run("max delay bounds the window", func(t *testing.T) {
+		rec := &digestRecorder{}
+		email := (*zeptomail.Email)(newTestClient(t, rec.handle))
+		digester, err := zeptomail.NewDigester(email, zeptomail.NewMemoryDigestStore(), zeptomail.DigestConfig{
+			From:     sender,
+			Window:   time.Hour,
+			MaxDelay: 30 * time.Millisecond,
+		})
+		require.NoError(t, err)
+		defer digester.Close()
+
+		require.NoError(t, digester.Add(t.Context(), event(receiver, 1)))
+		require.Eventually(t, func() bool { return len(rec.sent()) == 1 }, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("pending events survive a restart", func(t *testing.T) {
+		store, err := zeptomail.NewFileDigestStore(t.TempDir())
+		require.NoError(t, err)
+		rec := &digestRecorder{}
+		email := (*zeptomail.Email)(newTestClient(t, rec.handle))
+		cfg := zeptomail.DigestConfig{From: sender, Window: time.Hour}
+
+		digester, err := zeptomail.NewDigester(email, store, cfg)
+		require.NoError(t, err)
+		require.NoError(t, digester.Add(t.Context(), event(receiver, 1)))
+		require.NoError(t, digester.Add(t.Context(), event(receiver, 2)))
+		digester.Close()
+		assert.Empty(t, rec.sent())
+
+		restarted, err := zeptomail.NewDigester(email, store, cfg)
+		require.NoError(t, err)
+		require.NoError(t, restarted.Start(t.Context()))
+		require.NoError(t, restarted.Flush(t.Context()))
+		defer restarted.Close()
+
+		require.Len(t, rec.sent(), 1)
+		assert.EqualValues(t, 2, rec.sent()[0].MergeInfo["events_count"])
+		pending, err := store.Load(t.Context())
+		require.NoError(t, err)
+		assert.Empty(t, pending)
+	})
+}
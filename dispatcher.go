@@ -0,0 +1,353 @@
+package zeptomail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Priority is the lane a message is sent through by a Dispatcher.
+type Priority int
+
+const (
+	// PriorityNormal is the lane of messages enqueued without a priority.
+	PriorityNormal Priority = iota
+	// PriorityCritical is for mail a user is waiting on, such as password
+	// resets and 2FA codes. It is always served before the other lanes.
+	PriorityCritical
+	// PriorityBulk is for mail that can wait, such as newsletters.
+	PriorityBulk
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityNormal:
+		return "normal"
+	case PriorityCritical:
+		return "critical"
+	case PriorityBulk:
+		return "bulk"
+	}
+	return fmt.Sprintf("Priority(%d)", int(p))
+}
+
+// ErrDispatcherClosed is returned when enqueuing on a closed Dispatcher.
+var ErrDispatcherClosed = errors.New("dispatcher is closed")
+
+type (
+	// Outbound is a send request a Dispatcher can deliver: SendHTMLEmailReq,
+	// SendBatchHTMLEmailReq, SendTemplatedEmailReq or SendBatchTemplatedEmailReq.
+	Outbound interface {
+		dispatch(e *Email, ctx context.Context) (any, error)
+	}
+
+	// LaneConfig is the budget of a Dispatcher lane.
+	LaneConfig struct {
+		// Concurrency is the number of sends of the lane that may be in
+		// flight at once. Defaults to 1.
+		Concurrency int
+
+		// Rate is the number of sends per second the lane may start, with
+		// bursts of up to Burst. Zero means no limit.
+		Rate  float64
+		Burst int
+
+		// QueueSize is the number of messages the lane holds before Enqueue
+		// blocks. Defaults to 100.
+		QueueSize int
+	}
+
+	// DispatcherConfig holds the budgets of the lanes of a Dispatcher.
+	DispatcherConfig struct {
+		Critical LaneConfig
+		Normal   LaneConfig
+		Bulk     LaneConfig
+	}
+
+	// DispatchResult is the outcome of an enqueued message.
+	DispatchResult struct {
+		Priority Priority
+
+		// Response is the *WrappedResponse returned by the Email method
+		// matching the request type, e.g. *WrappedResponse[SendHTMLEmailRes].
+		Response any
+		Err      error
+	}
+
+	// EnqueueOption configures an enqueued message.
+	EnqueueOption func(*dispatchJob)
+)
+
+// WithPriority sends the message through the lane of p.
+func WithPriority(p Priority) EnqueueOption {
+	return func(j *dispatchJob) { j.priority = p }
+}
+
+// OnDispatched calls fn, from the worker goroutine, with the outcome of the
+// message. It runs before the message's Ticket is done.
+func OnDispatched(fn func(DispatchResult)) EnqueueOption {
+	return func(j *dispatchJob) { j.callbacks = append(j.callbacks, fn) }
+}
+
+func (r SendHTMLEmailReq) dispatch(e *Email, ctx context.Context) (any, error) {
+	return e.SendHTMLEmail(ctx, r)
+}
+
+func (r SendBatchHTMLEmailReq) dispatch(e *Email, ctx context.Context) (any, error) {
+	return e.SendBatchHTMLEmail(ctx, r)
+}
+
+func (r SendTemplatedEmailReq) dispatch(e *Email, ctx context.Context) (any, error) {
+	return e.SendTemplatedEmail(ctx, r)
+}
+
+func (r SendBatchTemplatedEmailReq) dispatch(e *Email, ctx context.Context) (any, error) {
+	return e.SendBatchTemplatedEmail(ctx, r)
+}
+
+// Ticket tracks a message enqueued on a Dispatcher.
+type Ticket struct {
+	done   chan struct{}
+	result DispatchResult
+}
+
+// Done is closed once the message has been sent or has failed.
+func (t *Ticket) Done() <-chan struct{} { return t.done }
+
+// Wait blocks until the message has been sent and returns its outcome.
+func (t *Ticket) Wait(ctx context.Context) (DispatchResult, error) {
+	select {
+	case <-t.done:
+		return t.result, t.result.Err
+	case <-ctx.Done():
+		return DispatchResult{}, ctx.Err()
+	}
+}
+
+type dispatchJob struct {
+	ctx       context.Context
+	msg       Outbound
+	priority  Priority
+	callbacks []func(DispatchResult)
+	ticket    *Ticket
+}
+
+type dispatchLane struct {
+	priority Priority
+	queue    chan *dispatchJob
+	limiter  *rate.Limiter
+}
+
+// Dispatcher sends messages asynchronously through an Email, in three
+// priority lanes with their own concurrency and rate budgets.
+//
+// Critical messages never wait behind the other lanes: besides its own
+// workers, every worker of the normal and bulk lanes takes queued critical
+// messages before its own, including while it waits for its lane's rate
+// limit, so a backlog of bulk mail cannot delay a password reset. A
+// critical message waits at most for the critical rate limit and for one
+// send in flight on a worker.
+type Dispatcher struct {
+	email *Email
+	lanes map[Priority]*dispatchLane
+
+	mu        sync.RWMutex
+	closed    bool
+	done      chan struct{}
+	enqueuing sync.WaitGroup
+	wg        sync.WaitGroup
+}
+
+// NewDispatcher starts a Dispatcher sending through email.
+func NewDispatcher(email *Email, cfg DispatcherConfig) *Dispatcher {
+	d := &Dispatcher{
+		email: email,
+		lanes: make(map[Priority]*dispatchLane, 3),
+		done:  make(chan struct{}),
+	}
+
+	configs := map[Priority]LaneConfig{
+		PriorityCritical: cfg.Critical,
+		PriorityNormal:   cfg.Normal,
+		PriorityBulk:     cfg.Bulk,
+	}
+	for p, lc := range configs {
+		if lc.QueueSize <= 0 {
+			lc.QueueSize = 100
+		}
+		if lc.Concurrency <= 0 {
+			lc.Concurrency = 1
+		}
+		limit, burst := rate.Inf, lc.Burst
+		if lc.Rate > 0 {
+			limit = rate.Limit(lc.Rate)
+			burst = max(burst, 1)
+		}
+		configs[p] = lc
+		d.lanes[p] = &dispatchLane{
+			priority: p,
+			queue:    make(chan *dispatchJob, lc.QueueSize),
+			limiter:  rate.NewLimiter(limit, burst),
+		}
+	}
+
+	for p, lc := range configs {
+		for range lc.Concurrency {
+			d.wg.Add(1)
+			go d.work(d.lanes[p])
+		}
+	}
+	return d
+}
+
+// Enqueue queues msg for sending, blocking while its lane is full, until
+// ctx is done or the Dispatcher is closed. The values of ctx are passed on
+// to the send, but its cancellation only applies to the wait for room in
+// the queue.
+func (d *Dispatcher) Enqueue(ctx context.Context, msg Outbound, opts ...EnqueueOption) (*Ticket, error) {
+	job := &dispatchJob{
+		ctx:    context.WithoutCancel(ctx),
+		msg:    msg,
+		ticket: &Ticket{done: make(chan struct{})},
+	}
+	for _, opt := range opts {
+		opt(job)
+	}
+	lane, ok := d.lanes[job.priority]
+	if !ok {
+		return nil, fmt.Errorf("unknown priority %d", job.priority)
+	}
+
+	d.mu.RLock()
+	if d.closed {
+		d.mu.RUnlock()
+		return nil, ErrDispatcherClosed
+	}
+	d.enqueuing.Add(1)
+	d.mu.RUnlock()
+	defer d.enqueuing.Done()
+
+	select {
+	case lane.queue <- job:
+		return job.ticket, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-d.done:
+		return nil, ErrDispatcherClosed
+	}
+}
+
+// Close stops accepting messages and waits for the queued ones to be sent,
+// or for ctx to be done.
+func (d *Dispatcher) Close(ctx context.Context) error {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		close(d.done)
+		// blocked Enqueue calls return once done is closed
+		d.enqueuing.Wait()
+		for _, lane := range d.lanes {
+			close(lane.queue)
+		}
+	}
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (d *Dispatcher) work(own *dispatchLane) {
+	defer d.wg.Done()
+
+	critical := d.lanes[PriorityCritical]
+	for {
+		if own != critical {
+			select {
+			case job, ok := <-critical.queue:
+				if ok {
+					d.run(critical, job)
+					continue
+				}
+			default:
+			}
+		}
+
+		select {
+		case job, ok := <-critical.queue:
+			if ok {
+				d.run(critical, job)
+				continue
+			}
+			if own == critical {
+				return
+			}
+			// the critical lane is closed and drained; stop selecting on it
+			critical = &dispatchLane{queue: make(chan *dispatchJob)}
+		case job, ok := <-own.queue:
+			if !ok {
+				return
+			}
+			d.run(own, job)
+		}
+	}
+}
+
+func (d *Dispatcher) run(lane *dispatchLane, job *dispatchJob) {
+	res := DispatchResult{Priority: lane.priority}
+	if res.Err = d.wait(lane); res.Err == nil {
+		res.Response, res.Err = job.msg.dispatch(d.email, job.ctx)
+	}
+
+	job.ticket.result = res
+	for _, fn := range job.callbacks {
+		fn(res)
+	}
+	close(job.ticket.done)
+}
+
+// wait blocks until the rate limit of lane allows a send. Workers of the
+// other lanes run queued critical messages meanwhile.
+func (d *Dispatcher) wait(lane *dispatchLane) error {
+	r := lane.limiter.Reserve()
+	if !r.OK() {
+		return fmt.Errorf("rate limit of the %s lane allows no sends", lane.priority)
+	}
+	delay := r.Delay()
+	if delay == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	critical := d.lanes[PriorityCritical]
+	queue := critical.queue
+	if lane == critical {
+		queue = nil
+	}
+	for {
+		select {
+		case <-timer.C:
+			return nil
+		case job, ok := <-queue:
+			if !ok {
+				queue = nil
+				continue
+			}
+			d.run(critical, job)
+		}
+	}
+}
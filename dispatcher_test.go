@@ -0,0 +1,228 @@
+package zeptomail_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/blancsoft/go-zeptomail"
+)
+
+func TestDispatcher(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		order []string
+		gate  = make(chan struct{})
+	)
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var req zeptomail.SendHTMLEmailReq
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if req.Subject == "bulk" {
+			<-gate
+		}
+		mu.Lock()
+		order = append(order, req.Subject)
+		mu.Unlock()
+		acceptHandler(w, r)
+	})
+
+	dispatcher := zeptomail.NewDispatcher((*zeptomail.Email)(client), zeptomail.DispatcherConfig{
+		Critical: zeptomail.LaneConfig{Concurrency: 1},
+		Normal:   zeptomail.LaneConfig{Concurrency: 1},
+		Bulk:     zeptomail.LaneConfig{Concurrency: 1, Rate: 1000, Burst: 1},
+	})
+
+	msg := func(subject string) zeptomail.SendHTMLEmailReq {
+		return zeptomail.SendHTMLEmailReq{
+			BaseSendEmail: zeptomail.BaseSendEmail{
+				From:      sender,
+				To:        []zeptomail.SendEmailTo{{EmailAddress: receiver}},
+				MergeInfo: map[string]any{},
+			},
+			Subject:  subject,
+			HtmlBody: emailBody,
+		}
+	}
+
+	var bulk []*zeptomail.Ticket
+	for range 3 {
+		ticket, err := dispatcher.Enqueue(t.Context(), msg("bulk"), zeptomail.WithPriority(zeptomail.PriorityBulk))
+		require.NoError(t, err)
+		bulk = append(bulk, ticket)
+	}
+
+	var callback zeptomail.DispatchResult
+	critical, err := dispatcher.Enqueue(t.Context(), msg("critical"),
+		zeptomail.WithPriority(zeptomail.PriorityCritical),
+		zeptomail.OnDispatched(func(res zeptomail.DispatchResult) { callback = res }),
+	)
+	require.NoError(t, err)
+
+	ctx := t.Context()
+	res, err := critical.Wait(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, zeptomail.PriorityCritical, res.Priority)
+	rv, ok := res.Response.(*zeptomail.WrappedResponse[zeptomail.SendHTMLEmailRes])
+	require.True(t, ok)
+	assert.Equal(t, "OK", rv.Data.Message)
+	assert.Equal(t, res, callback)
+
+	normal, err := dispatcher.Enqueue(t.Context(), msg("normal"))
+	require.NoError(t, err)
+	res, err = normal.Wait(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, zeptomail.PriorityNormal, res.Priority)
+
+	close(gate)
+	require.NoError(t, dispatcher.Close(ctx))
+	for _, ticket := range bulk {
+		select {
+		case <-ticket.Done():
+		case <-time.After(time.Second):
+			t.Fatal("bulk message was not sent before Close returned")
+		}
+	}
+	assert.Equal(t, []string{"critical", "normal", "bulk", "bulk", "bulk"}, order)
+
+	_, err = dispatcher.Enqueue(t.Context(), msg("late"))
+	assert.ErrorIs(t, err, zeptomail.ErrDispatcherClosed)
+}
+
+func TestDispatcherCriticalBorrowsIdleWorkers(t *testing.T) {
+	gate := make(chan struct{})
+	var sent sync.WaitGroup
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var req zeptomail.SendHTMLEmailReq
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if req.Subject == "slow" {
+			<-gate
+		}
+		acceptHandler(w, r)
+		sent.Done()
+	})
+	dispatcher := zeptomail.NewDispatcher((*zeptomail.Email)(client), zeptomail.DispatcherConfig{
+		Critical: zeptomail.LaneConfig{Concurrency: 1},
+		Normal:   zeptomail.LaneConfig{Concurrency: 2},
+	})
+
+	msg := func(subject string) zeptomail.SendHTMLEmailReq {
+		return zeptomail.SendHTMLEmailReq{
+			BaseSendEmail: zeptomail.BaseSendEmail{
+				From:      sender,
+				To:        []zeptomail.SendEmailTo{{EmailAddress: receiver}},
+				MergeInfo: map[string]any{},
+			},
+			Subject:  subject,
+			HtmlBody: emailBody,
+		}
+	}
+
+	// occupy the only critical worker
+	sent.Add(2)
+	_, err := dispatcher.Enqueue(t.Context(), msg("slow"), zeptomail.WithPriority(zeptomail.PriorityCritical))
+	require.NoError(t, err)
+	fast, err := dispatcher.Enqueue(t.Context(), msg("fast"), zeptomail.WithPriority(zeptomail.PriorityCritical))
+	require.NoError(t, err)
+
+	select {
+	case <-fast.Done():
+	case <-time.After(time.Second):
+		t.Fatal("critical message waited for the busy critical worker")
+	}
+	close(gate)
+	sent.Wait()
+	require.NoError(t, dispatcher.Close(t.Context()))
+}
+
+// dispatchMsg is a valid HTML send request with the given subject.
+func dispatchMsg(subject string) zeptomail.SendHTMLEmailReq {
+	return zeptomail.SendHTMLEmailReq{
+		BaseSendEmail: zeptomail.BaseSendEmail{
+			From:      sender,
+			To:        []zeptomail.SendEmailTo{{EmailAddress: receiver}},
+			MergeInfo: map[string]any{},
+		},
+		Subject:  subject,
+		HtmlBody: emailBody,
+	}
+}
+
+func TestDispatcherCloseUnblocksEnqueue(t *testing.T) {
+	gate := make(chan struct{})
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		<-gate
+		acceptHandler(w, r)
+	})
+	dispatcher := zeptomail.NewDispatcher((*zeptomail.Email)(client), zeptomail.DispatcherConfig{
+		Normal: zeptomail.LaneConfig{QueueSize: 1},
+	})
+
+	// one message in flight, one queued, and one waiting for room
+	for range 2 {
+		_, err := dispatcher.Enqueue(t.Context(), dispatchMsg("queued"))
+		require.NoError(t, err)
+		time.Sleep(10 * time.Millisecond)
+	}
+	blocked := make(chan error)
+	go func() {
+		_, err := dispatcher.Enqueue(t.Context(), dispatchMsg("blocked"))
+		blocked <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	closed := make(chan error)
+	go func() { closed <- dispatcher.Close(t.Context()) }()
+	select {
+	case err := <-blocked:
+		assert.ErrorIs(t, err, zeptomail.ErrDispatcherClosed)
+	case <-time.After(time.Second):
+		t.Fatal("Close hung on a blocked Enqueue")
+	}
+	close(gate)
+	require.NoError(t, <-closed)
+}
+
+func TestDispatcherCriticalSkipsRateLimitWait(t *testing.T) {
+	gate := make(chan struct{})
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var req zeptomail.SendHTMLEmailReq
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if req.Subject == "slow" {
+			<-gate
+		}
+		acceptHandler(w, r)
+	})
+	dispatcher := zeptomail.NewDispatcher((*zeptomail.Email)(client), zeptomail.DispatcherConfig{
+		Bulk: zeptomail.LaneConfig{Rate: 0.5, Burst: 1},
+	})
+
+	// the second bulk message leaves the bulk worker waiting for two seconds
+	for range 2 {
+		_, err := dispatcher.Enqueue(t.Context(), dispatchMsg("bulk"), zeptomail.WithPriority(zeptomail.PriorityBulk))
+		require.NoError(t, err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	// occupy the normal worker, and the critical one unless the bulk worker
+	// takes the message
+	_, err := dispatcher.Enqueue(t.Context(), dispatchMsg("slow"))
+	require.NoError(t, err)
+	_, err = dispatcher.Enqueue(t.Context(), dispatchMsg("slow"), zeptomail.WithPriority(zeptomail.PriorityCritical))
+	require.NoError(t, err)
+	time.Sleep(20 * time.Millisecond)
+
+	critical, err := dispatcher.Enqueue(t.Context(), dispatchMsg("fast"), zeptomail.WithPriority(zeptomail.PriorityCritical))
+	require.NoError(t, err)
+	select {
+	case <-critical.Done():
+	case <-time.After(time.Second):
+		t.Fatal("critical message waited for the bulk rate limit")
+	}
+	close(gate)
+	require.NoError(t, dispatcher.Close(t.Context()))
+}
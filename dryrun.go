@@ -0,0 +1,120 @@
+package zeptomail
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// DryRunRequest is an API request answered by a dry run.
+type DryRunRequest struct {
+	Method string
+	URL    string
+
+	// Header holds the request headers, without Authorization and its API
+	// key
+	Header http.Header
+
+	// The encoded JSON payload, as it would have been sent
+	Body []byte
+
+	// The request_id of the synthetic response
+	RequestID string
+	Time      time.Time
+}
+
+// Decode decodes the payload of r into v.
+func (r DryRunRequest) Decode(v any) error {
+	return json.Unmarshal(r.Body, v)
+}
+
+// DryRun records the requests of a client in dry-run mode, see
+// Email.UseDryRun.
+type DryRun struct {
+	mu       sync.Mutex
+	requests []DryRunRequest
+}
+
+// Requests returns the requests recorded so far, oldest first.
+func (d *DryRun) Requests() []DryRunRequest {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return slices.Clone(d.requests)
+}
+
+// Last returns the latest request, and false if there is none.
+func (d *DryRun) Last() (DryRunRequest, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.requests) == 0 {
+		return DryRunRequest{}, false
+	}
+	return d.requests[len(d.requests)-1], true
+}
+
+// Reset forgets the recorded requests.
+func (d *DryRun) Reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.requests = nil
+}
+
+// UseDryRun puts e in dry-run mode: sends are transformed, validated and
+// encoded as usual, but the encoded request is recorded in the returned
+// DryRun instead of reaching ZeptoMail, and answered with an accepted
+// response carrying a made up request_id starting with "dryrun-".
+//
+// No send reaches the API in dry-run mode. Attachments are not offloaded
+// to File Cache, see UseFileCacheOffload, and templated emails are not
+// checked by UseMergeTagLint, as both would call the API. Sends are not
+// recorded by UseIdempotency either, so a later real send with the same
+// key is not skipped. The mode belongs
+// to the Client of e: calls made through another Client still reach the
+// API.
+func (e *Email) UseDryRun() *DryRun {
+	e.dryRun = &DryRun{}
+	return e.dryRun
+}
+
+// dryRunDo records req in d and answers it with a synthetic accepted
+// response.
+func dryRunDo[R any](d *DryRun, req *http.Request) (*WrappedResponse[R], error) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, fmt.Errorf("encoding failed: %w", err)
+	}
+
+	header := req.Header.Clone()
+	header.Del("Authorization")
+	rec := DryRunRequest{
+		Method:    req.Method,
+		URL:       req.URL.String(),
+		Header:    header,
+		Body:      body,
+		RequestID: "dryrun-" + randomHex(8),
+		Time:      time.Now(),
+	}
+	d.mu.Lock()
+	d.requests = append(d.requests, rec)
+	d.mu.Unlock()
+
+	resBody, err := json.Marshal(SendHTMLEmailRes{
+		Data:      []SendEmailResData{{Code: "EM_104", AdditionalInfo: []any{}, Message: "Email request received"}},
+		Message:   "OK",
+		RequestId: rec.RequestID,
+		Object:    "email",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	status := http.StatusOK
+	if req.Method == http.MethodPost {
+		status = http.StatusCreated
+	}
+	return decodeResponse[R](newJSONResponse(req, status, resBody))
+}
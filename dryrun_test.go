@@ -0,0 +1,129 @@
+package zeptomail_test
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/blancsoft/go-zeptomail"
+)
+
+func TestDryRun(t *testing.T) {
+	email := (*zeptomail.Email)(newTestClient(t, func(http.ResponseWriter, *http.Request) {
+		t.Error("a dry run reached the API")
+	}))
+	email.UseTextAlternative()
+	dryRun := email.UseDryRun()
+
+	res, err := email.SendHTMLEmail(t.Context(), zeptomail.SendHTMLEmailReq{
+		BaseSendEmail: zeptomail.BaseSendEmail{
+			From:      sender,
+			To:        []zeptomail.SendEmailTo{{EmailAddress: receiver}},
+			MergeInfo: map[string]any{"name": "Ada"},
+		},
+		BaseEmailOption: zeptomail.BaseEmailOption{
+			Attachments: []zeptomail.EmailAttachment{{
+				Name:     "note.txt",
+				MimeType: "text/plain",
+				Reader:   strings.NewReader("streamed"),
+			}},
+		},
+		Subject:  emailSubject,
+		HtmlBody: "<p>Hello</p>",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, res.RawResponse.StatusCode)
+	assert.True(t, strings.HasPrefix(res.Data.RequestId, "dryrun-"))
+	assert.Equal(t, "EM_104", res.Data.Data[0].Code)
+
+	last, ok := dryRun.Last()
+	require.True(t, ok)
+	assert.Equal(t, res.Data.RequestId, last.RequestID)
+	assert.Equal(t, http.MethodPost, last.Method)
+	assert.True(t, strings.HasSuffix(last.URL, "/email"))
+	assert.NotContains(t, last.Header, "Authorization", "the API key is not recorded")
+	assert.Equal(t, "application/json", last.Header.Get("Content-Type"))
+
+	var sent map[string]any
+	require.NoError(t, last.Decode(&sent))
+	assert.Equal(t, "Hello", sent["textbody"], "transformers ran")
+	attachment := sent["attachments"].([]any)[0].(map[string]any)
+	assert.Equal(t, "c3RyZWFtZWQ=", attachment["content"], "streamed attachments are encoded")
+
+	_, err = email.SendHTMLEmail(t.Context(), zeptomail.SendHTMLEmailReq{Subject: emailSubject})
+	assert.Error(t, err, "dry runs still validate requests")
+	assert.Len(t, dryRun.Requests(), 1)
+
+	dryRun.Reset()
+	assert.Empty(t, dryRun.Requests())
+}
+
+func TestDryRunMakesNoAPICalls(t *testing.T) {
+	noCalls := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		t.Errorf("a dry run called %s", r.URL)
+		return nil, errors.New("no API calls in dry runs")
+	})}
+	client, err := zeptomail.NewClient("test-agent", "Zoho-enczapikey test", noCalls)
+	require.NoError(t, err)
+	templates, err := zeptomail.NewClient("test-agent", "Zoho-enczapikey test", noCalls)
+	require.NoError(t, err)
+
+	email := (*zeptomail.Email)(client)
+	email.UseMergeTagLint((*zeptomail.Template)(templates), 0)
+	email.UseFileCacheOffload(1)
+	dryRun := email.UseDryRun()
+
+	_, err = email.SendTemplatedEmail(t.Context(), zeptomail.SendTemplatedEmailReq{
+		BaseSendEmail: zeptomail.BaseSendEmail{
+			From:      sender,
+			To:        []zeptomail.SendEmailTo{{EmailAddress: receiver}},
+			MergeInfo: map[string]any{"name": "Ada"},
+		},
+		BaseEmailOption: zeptomail.BaseEmailOption{Attachments: attachment},
+		TemplateKey:     "tpl",
+	})
+	require.NoError(t, err)
+	_, err = email.SendHTMLEmail(t.Context(), zeptomail.SendHTMLEmailReq{
+		BaseSendEmail: zeptomail.BaseSendEmail{
+			From:      sender,
+			To:        []zeptomail.SendEmailTo{{EmailAddress: receiver}},
+			MergeInfo: map[string]any{"name": "Ada", "testType": "Dry run"},
+		},
+		Subject:  emailSubject,
+		HtmlBody: "<p>Hello {{name}}</p>",
+	})
+	require.NoError(t, err)
+	assert.Len(t, dryRun.Requests(), 2)
+}
+
+func TestDryRunIsNotRecordedForIdempotency(t *testing.T) {
+	var calls atomic.Int32
+	store := zeptomail.NewMemoryIdempotencyStore(10)
+	newEmail := func() *zeptomail.Email {
+		email := (*zeptomail.Email)(newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			acceptHandler(w, r)
+		}))
+		email.UseIdempotency(store, time.Minute)
+		return email
+	}
+	dryRun := newEmail()
+	dryRun.UseDryRun()
+	live := newEmail()
+
+	ctx := zeptomail.WithIdempotencyKey(t.Context(), "receipt-1")
+	res, err := dryRun.SendHTMLEmail(ctx, dispatchMsg(emailSubject))
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(res.Data.RequestId, "dryrun-"))
+
+	res, err = live.SendHTMLEmail(ctx, dispatchMsg(emailSubject))
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, calls.Load(), "the real send reached the API")
+	assert.False(t, strings.HasPrefix(res.Data.RequestId, "dryrun-"))
+}
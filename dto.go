@@ -1,5 +1,7 @@
 package zeptomail
 
+import "io"
+
 type (
 
 	// ErrorResponse is an object for errors
@@ -20,31 +22,33 @@ type (
 	}
 
 	BaseEmailOption struct {
-		CC  []SendEmailTo `json:"cc"`
-		BCC []SendEmailTo `json:"bcc"`
+		CC  []SendEmailTo `json:"cc,omitempty"`
+		BCC []SendEmailTo `json:"bcc,omitempty"`
 
 		// Allowed value - JSON object of reply_to email addresses.
-		ReplyTo []EmailAddress `json:"reply_to"`
+		ReplyTo []EmailAddress `json:"reply_to,omitempty"`
 
 		//You can enable or disable email click tracking here. You can also
 		//enable email click tracking in your Mail Agent under Email Tracking
 		//section. Note: The API setting will override the Mail Agent settings
 		//in your ZeptoMail account.
 		//Allowed value: True - Enable email click tracking; False - Disable email click tracking.
-		TrackClicks bool `json:"track_clicks"`
+		//Leave it nil to use the Mail Agent setting, see Bool.
+		TrackClicks *bool `json:"track_clicks,omitempty"`
 
 		//You can enable or disable email open tracking. You can also enable
 		//email open tracking in your Mail Agent under Email Tracking section.
 		//Note: The API setting will override the Mail Agent settings in your
 		//ZeptoMail account. Allowed value: True - Enable email open tracking;
-		//False - Disable email open tracking.
-		TrackOpens bool `json:"track_opens"`
+		//False - Disable email open tracking. Leave it nil to use the Mail
+		//Agent setting, see Bool.
+		TrackOpens *bool `json:"track_opens,omitempty"`
 
 		// An identifier set by the user to track a particular transaction.
-		ClientReference string `json:"client_reference"`
+		ClientReference string `json:"client_reference,omitempty"`
 
 		// The additional headers to be sent in the email for your reference purposes.
-		MimeHeaders map[string]any `json:"mime_headers"`
+		MimeHeaders Headers `json:"mime_headers,omitempty"`
 
 		//The attachments you want to add to your transactional emails.
 		//Visit [https://www.zoho.com/zeptomail/help/file-cache.html#alink-un-sup-for]
@@ -56,11 +60,24 @@ type (
 		//Inline images added to email.
 		//Allowed value - Base64 content or file_cache_key or both.
 		//Base64 content includes mime_type, content, and cid parameters.
-		//
-		//cid String
-		//Content ID used by HTML body for content lookup. Each content requires a separate cid.
-		//Allowed value - Base64 content or file_cache_key or both.
-		InlineImages []any `json:"inline_images"`
+		InlineImages []InlineImage `json:"inline_images,omitempty"`
+	}
+
+	// InlineImage is an image embedded in the HTML body, which refers to it
+	// as "cid:" followed by its Cid.
+	InlineImage struct {
+		// Indicates the content type of the image, e.g. image/png.
+		MimeType string `json:"mime_type,omitempty"`
+
+		// Base64 encoded content of the image.
+		Content string `json:"content,omitempty"`
+
+		//Content ID used by HTML body for content lookup. Each content
+		//requires a separate cid.
+		Cid string `json:"cid"`
+
+		// The unique key of the image in File Cache, used instead of Content.
+		FileCacheKey string `json:"file_cache_key,omitempty"`
 	}
 
 	BaseSendEmail struct {
@@ -87,7 +104,12 @@ type (
 		BaseSendEmail
 		BaseEmailOption
 		Subject  string `json:"subject" validate:"required"`
-		HtmlBody string `json:"htmlbody" validate:"required"`
+		HtmlBody string `json:"htmlbody,omitempty" validate:"required_without=TextBody"`
+
+		// Plain text body of the email, shown by clients that do not render
+		// HTML. Either HtmlBody or TextBody is required; Email.UseTextAlternative
+		// derives it from HtmlBody.
+		TextBody string `json:"textbody,omitempty"`
 	}
 
 	// SendHTMLEmailRes is the SendHTMLEmail() response object
@@ -119,7 +141,7 @@ type (
 		//The email address to which bounced emails will be sent.
 		//Allowed value - A valid bounce email address as configured in
 		//your Mail Agent.
-		BounceAddress string `json:"bounce_address"`
+		BounceAddress string `json:"bounce_address,omitempty"`
 	}
 
 	// SendEmailTo is the object for recipient email
@@ -150,8 +172,8 @@ type (
 		//in your Mail Agent.
 		Address string `json:"address" validate:"required"`
 
-		// Recipient's name.
-		Name string `json:"name" validate:"required"`
+		// Recipient's name. Optional.
+		Name string `json:"name,omitempty"`
 	}
 
 	// SendTemplatedEmailRes is the SendTemplatedEmail() response object
@@ -200,6 +222,12 @@ type (
 			It can either a base64 encoded content or a file_cache_key or both.
 		*/
 		Cid string `json:"cid,omitempty"`
+
+		// Reader, when set, supplies the raw content of the attachment in
+		// place of Content. It is base64 encoded into the request body while
+		// the request is being sent, so the attachment is never held in
+		// memory as a whole. It can only be read, and so sent, once.
+		Reader io.Reader `json:"-"`
 	}
 
 	// SendBatchTemplatedEmailReq is the SendBatchTemplatedEmail() request object
@@ -214,13 +242,13 @@ type (
 
 			Allowed value - A valid bounce email address as configured in your Mail Agent.
 		*/
-		BounceAddress string `json:"bounce_address"`
+		BounceAddress string `json:"bounce_address,omitempty"`
 		// Allowed value - A valid sender email address with "address" and "name" key-value pairs
 		From EmailAddress `json:"from" validate:"required"`
 		// Allowed value - JSON object of email_address.
 		To []SendBatchEmailTo `json:"to" validate:"required"`
 		// Allowed value - JSON object of reply_to email addresses.
-		ReplyTo EmailAddress `json:"reply_to"`
+		ReplyTo EmailAddress `json:"reply_to,omitzero"`
 		/*
 			You can enable or disable email click tracking here.
 
@@ -233,8 +261,10 @@ type (
 			True - Enable email click tracking.
 
 			False - Disable email click tracking.
+
+			nil - Use the Mail Agent setting, see Bool.
 		*/
-		TrackClicks bool `json:"track_clicks"`
+		TrackClicks *bool `json:"track_clicks,omitempty"`
 		/*
 					You can enable or disable email open tracking.
 
@@ -247,12 +277,14 @@ type (
 			True - Enable email open tracking.
 
 			False - Disable email open tracking.
+
+			nil - Use the Mail Agent setting, see Bool.
 		*/
-		TrackOpens bool `json:"track_opens"`
+		TrackOpens *bool `json:"track_opens,omitempty"`
 		// An identifier set by the user to track a particular transaction.
-		ClientReference string `json:"client_reference"`
+		ClientReference string `json:"client_reference,omitempty"`
 		// The additional headers to be sent in the email for your reference purposes.
-		MimeHeaders map[string]any `json:"mime_headers"`
+		MimeHeaders Headers `json:"mime_headers,omitempty"`
 		/*
 			The attachments you want to add to your transactional emails. Visit [https://www.zoho.com/zeptomail/help/file-cache.html#alink-un-sup-for] to view the list of unsupported formats.
 
@@ -346,10 +378,16 @@ type (
 
 	// SendBatchHTMLEmailReq is the SendBatchHTMLEmail() request object
 	SendBatchHTMLEmailReq struct {
-		From     EmailAddress       `json:"from" validate:"required"`
-		To       []SendBatchEmailTo `json:"to" validate:"required"`
-		Subject  string             `json:"subject" validate:"required"`
-		HtmlBody string             `json:"htmlbody" validate:"required"`
+		From EmailAddress       `json:"from" validate:"required"`
+		To   []SendBatchEmailTo `json:"to" validate:"required"`
+		BaseEmailOption
+		Subject  string `json:"subject" validate:"required"`
+		HtmlBody string `json:"htmlbody,omitempty" validate:"required_without=TextBody"`
+
+		// Plain text body of the email, shown by clients that do not render
+		// HTML. Either HtmlBody or TextBody is required; Email.UseTextAlternative
+		// derives it from HtmlBody.
+		TextBody string `json:"textbody,omitempty"`
 	}
 
 	// SendBatchHTMLEmailRes is the SendHTMLEmail() response object
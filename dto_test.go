@@ -0,0 +1,91 @@
+package zeptomail_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/blancsoft/go-zeptomail"
+)
+
+func TestRequestOptions(t *testing.T) {
+	var sent map[string]any
+	email := (*zeptomail.Email)(newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		sent = nil
+		b, _ := io.ReadAll(r.Body)
+		require.NoError(t, json.Unmarshal(b, &sent))
+		acceptHandler(w, r)
+	}))
+
+	req := zeptomail.SendHTMLEmailReq{
+		BaseSendEmail: zeptomail.BaseSendEmail{
+			From:      sender,
+			To:        []zeptomail.SendEmailTo{{EmailAddress: receiver}},
+			MergeInfo: map[string]any{"name": "Ada"},
+		},
+		Subject:  emailSubject,
+		HtmlBody: emailBody,
+	}
+	_, err := email.SendHTMLEmail(t.Context(), req)
+	require.NoError(t, err)
+	for _, key := range []string{"track_clicks", "track_opens", "cc", "bcc", "reply_to", "client_reference", "mime_headers"} {
+		assert.NotContains(t, sent, key, "unset options leave the Mail Agent settings alone")
+	}
+
+	req.TrackClicks = zeptomail.Bool(false)
+	_, err = email.SendHTMLEmail(t.Context(), req)
+	require.NoError(t, err)
+	assert.Equal(t, false, sent["track_clicks"])
+	assert.NotContains(t, sent, "track_opens")
+
+	_, err = email.SendBatchHTMLEmail(t.Context(), zeptomail.SendBatchHTMLEmailReq{
+		From: sender,
+		To:   []zeptomail.SendBatchEmailTo{{EmailAddress: receiver, MergeInfo: map[string]any{"name": "Ada"}}},
+		BaseEmailOption: zeptomail.BaseEmailOption{
+			CC:              []zeptomail.SendEmailTo{{EmailAddress: other}},
+			ReplyTo:         []zeptomail.EmailAddress{sender},
+			TrackOpens:      zeptomail.Bool(true),
+			ClientReference: "batch-1",
+			MimeHeaders:     testHeaders,
+			Attachments:     attachment,
+		},
+		Subject:  emailSubject,
+		HtmlBody: emailBody,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, true, sent["track_opens"])
+	assert.Equal(t, "batch-1", sent["client_reference"])
+	assert.Equal(t, map[string]any{"X-Tester": "go-zeptomail"}, sent["mime_headers"])
+	assert.Len(t, sent["cc"], 1)
+	assert.Len(t, sent["attachments"], 1)
+}
+
+func TestTemplatedRequestOptions(t *testing.T) {
+	for name, req := range map[string]any{
+		"templated": zeptomail.SendTemplatedEmailReq{
+			BaseSendEmail: zeptomail.BaseSendEmail{
+				From:      sender,
+				To:        []zeptomail.SendEmailTo{{EmailAddress: receiver}},
+				MergeInfo: map[string]any{},
+			},
+			TemplateKey: "tpl",
+		},
+		"batch templated": zeptomail.SendBatchTemplatedEmailReq{
+			From:        sender,
+			To:          []zeptomail.SendBatchEmailTo{{EmailAddress: receiver, MergeInfo: map[string]any{}}},
+			TemplateKey: "tpl",
+		},
+	} {
+		b, err := json.Marshal(req)
+		require.NoError(t, err)
+		var encoded map[string]any
+		require.NoError(t, json.Unmarshal(b, &encoded))
+		for _, key := range []string{"reply_to", "bounce_address"} {
+			assert.NotContains(t, encoded, key, name)
+		}
+	}
+}
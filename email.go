@@ -9,28 +9,49 @@ type Email Client
 
 // SendHTMLEmail sends a HTML email
 func (e *Email) SendHTMLEmail(ctx context.Context, req SendHTMLEmailReq) (*WrappedResponse[SendHTMLEmailRes], error) {
-	path := "/email"
-	endpoint := e.baseURL.JoinPath(path)
-	return request[SendHTMLEmailReq, SendHTMLEmailRes]((*Client)(e), ctx, http.MethodPost, endpoint, nil, req)
+	return send[SendHTMLEmailReq, SendHTMLEmailRes](e, ctx, "/email", req)
 }
 
 // SendBatchHTMLEmail The API is used to send a batch of transactional HTML emails.
 func (e *Email) SendBatchHTMLEmail(ctx context.Context, req SendBatchHTMLEmailReq) (*WrappedResponse[SendBatchHTMLEmailRes], error) {
-	path := "/email/batch"
-	endpoint := e.baseURL.JoinPath(path)
-	return request[SendBatchHTMLEmailReq, SendBatchHTMLEmailRes]((*Client)(e), ctx, http.MethodPost, endpoint, nil, req)
+	return send[SendBatchHTMLEmailReq, SendBatchHTMLEmailRes](e, ctx, "/email/batch", req)
 }
 
 // SendTemplatedEmail sends a templated email
 func (e *Email) SendTemplatedEmail(ctx context.Context, req SendTemplatedEmailReq) (*WrappedResponse[SendTemplatedEmailRes], error) {
-	path := "/email/template"
-	endpoint := e.baseURL.JoinPath(path)
-	return request[SendTemplatedEmailReq, SendTemplatedEmailRes]((*Client)(e), ctx, http.MethodPost, endpoint, nil, req)
+	return send[SendTemplatedEmailReq, SendTemplatedEmailRes](e, ctx, "/email/template", req)
 }
 
 // SendBatchTemplatedEmail sends a batch templated email
 func (e *Email) SendBatchTemplatedEmail(ctx context.Context, req SendBatchTemplatedEmailReq) (*WrappedResponse[SendTemplatedEmailRes], error) {
-	path := "/email/template/batch"
+	return send[SendBatchTemplatedEmailReq, SendTemplatedEmailRes](e, ctx, "/email/template/batch", req)
+}
+
+// send posts req to the given path, running it through the layers enabled on e.
+func send[S any, R any](e *Email, ctx context.Context, path string, req S) (*WrappedResponse[R], error) {
+	if err := transform(e, ctx, &req); err != nil {
+		return nil, err
+	}
+	if err := mimeHeaders(&req).Validate(); err != nil {
+		return nil, err
+	}
+	if e.mergeLint != nil {
+		if err := e.mergeLint.lintRequest(ctx, req, e.dryRun == nil); err != nil {
+			return nil, err
+		}
+	}
+
 	endpoint := e.baseURL.JoinPath(path)
-	return request[SendBatchTemplatedEmailReq, SendTemplatedEmailRes]((*Client)(e), ctx, http.MethodPost, endpoint, nil, req)
+	do := func(ctx context.Context) (*WrappedResponse[R], error) {
+		if e.offload != nil && e.dryRun == nil {
+			return sendOffloaded[S, R](e, ctx, endpoint, req)
+		}
+		return request[S, R]((*Client)(e), ctx, http.MethodPost, endpoint, nil, req)
+	}
+
+	// dry runs neither replay nor record sends, so real ones are not skipped
+	if e.idempotency != nil && e.dryRun == nil {
+		return idempotent(e.idempotency, ctx, idempotencyKey(ctx, path, req), do)
+	}
+	return do(ctx)
 }
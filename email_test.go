@@ -61,8 +61,8 @@ func TestHTMLEmail(t *testing.T) {
 				CC:              []zeptomail.SendEmailTo{{EmailAddress: receiver}},
 				BCC:             []zeptomail.SendEmailTo{{EmailAddress: receiver}},
 				ReplyTo:         []zeptomail.EmailAddress{sender},
-				TrackClicks:     true,
-				TrackOpens:      true,
+				TrackClicks:     zeptomail.Bool(true),
+				TrackOpens:      zeptomail.Bool(true),
 				ClientReference: ref,
 				MimeHeaders:     testHeaders,
 				Attachments:     attachment,
@@ -163,8 +163,8 @@ func TestTemplateEmail(t *testing.T) {
 				CC:              []zeptomail.SendEmailTo{{EmailAddress: receiver}},
 				BCC:             []zeptomail.SendEmailTo{{EmailAddress: receiver}},
 				ReplyTo:         []zeptomail.EmailAddress{sender},
-				TrackClicks:     true,
-				TrackOpens:      true,
+				TrackClicks:     zeptomail.Bool(true),
+				TrackOpens:      zeptomail.Bool(true),
 				ClientReference: ref,
 				MimeHeaders:     testHeaders,
 				Attachments:     attachment,
@@ -203,8 +203,8 @@ func TestTemplateEmail(t *testing.T) {
 				{EmailAddress: other, MergeInfo: map[string]any{"name": "Other", "link": "https://blancsoft.com", "testType": "Batch Templated"}},
 			},
 			ReplyTo:         sender,
-			TrackClicks:     true,
-			TrackOpens:      true,
+			TrackClicks:     zeptomail.Bool(true),
+			TrackOpens:      zeptomail.Bool(true),
 			ClientReference: ref,
 			MimeHeaders:     testHeaders,
 			Attachments:     attachment,
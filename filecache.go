@@ -1,19 +1,148 @@
 package zeptomail
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"fmt"
+	"io"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 )
 
 type FileCache Client
 
 // FileCacheUploadAPI The API is used to upload files to File Cache
 func (f *FileCache) FileCacheUploadAPI(ctx context.Context, req FileCacheUploadAPIReq) (*WrappedResponse[FileCacheUploadAPIRes], error) {
-	path := "/files"
-	query := url.Values{"name": []string{req.FileName}}
-	header := http.Header{http.CanonicalHeaderKey("Content-Type"): {"text/plain"}}
-	endpoint := f.baseURL.JoinPath(path)
-	endpoint.RawQuery = query.Encode()
-	return request[FileCacheUploadAPIReq, FileCacheUploadAPIRes]((*Client)(f), ctx, http.MethodPost, endpoint, header, req)
+	if err := validate.Struct(&req); err != nil {
+		return nil, err
+	}
+	return f.Upload(ctx, req.FileName, bytes.NewReader(req.FileContent))
+}
+
+// UploadOption configures an upload to File Cache.
+type UploadOption func(*uploadConfig)
+
+type uploadConfig struct {
+	contentType   string
+	contentLength int64
+	progress      func(sent, total int64)
+}
+
+// WithContentType sets the content type of the upload instead of
+// detecting it from the file name and content.
+func WithContentType(contentType string) UploadOption {
+	return func(c *uploadConfig) { c.contentType = contentType }
+}
+
+// WithContentLength sets the size of the upload, for readers whose size
+// cannot be found out otherwise. Uploads of unknown size are sent chunked.
+func WithContentLength(n int64) UploadOption {
+	return func(c *uploadConfig) { c.contentLength = n }
+}
+
+// WithUploadProgress calls fn as the upload is sent, with the number of
+// bytes sent so far and the total, or -1 if it is unknown.
+func WithUploadProgress(fn func(sent, total int64)) UploadOption {
+	return func(c *uploadConfig) { c.progress = fn }
+}
+
+// Upload streams the content of r to File Cache as the file name. The
+// content is sent as is, without being held in memory, with its content
+// type detected from name and its first bytes.
+func (f *FileCache) Upload(ctx context.Context, name string, r io.Reader, opts ...UploadOption) (*WrappedResponse[FileCacheUploadAPIRes], error) {
+	if name == "" {
+		return nil, fmt.Errorf("file name is required")
+	}
+	if err := checkAttachmentName(name); err != nil {
+		return nil, err
+	}
+
+	cfg := uploadConfig{contentLength: readerLen(r)}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	br := bufio.NewReaderSize(r, 512)
+	if cfg.contentType == "" {
+		head, err := br.Peek(512)
+		if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+			return nil, fmt.Errorf("reading %s failed: %w", name, err)
+		}
+		cfg.contentType = DetectContentType(name, head)
+	}
+
+	var body io.Reader = br
+	if cfg.progress != nil {
+		body = &progressReader{r: br, total: cfg.contentLength, fn: cfg.progress}
+	}
+
+	endpoint := f.baseURL.JoinPath("/files")
+	endpoint.RawQuery = url.Values{"name": []string{name}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.String(), io.NopCloser(body))
+	if err != nil {
+		return nil, fmt.Errorf("new request failed: %w", err)
+	}
+	req.ContentLength = cfg.contentLength
+	if req.ContentLength == 0 {
+		// zero means unknown to http.Client when the body is set
+		req.Body = http.NoBody
+	}
+	req.Header.Set("Content-Type", cfg.contentType)
+	req.Header.Set("Authorization", f.authorisation)
+	return do[FileCacheUploadAPIRes]((*Client)(f), req)
+}
+
+// UploadFile streams the file at path to File Cache, named after its base name.
+func (f *FileCache) UploadFile(ctx context.Context, path string, opts ...UploadOption) (*WrappedResponse[FileCacheUploadAPIRes], error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+	return f.Upload(ctx, filepath.Base(path), file, opts...)
+}
+
+// readerLen returns the number of bytes left in r, or -1 if it cannot be
+// told without reading r.
+func readerLen(r io.Reader) int64 {
+	switch r := r.(type) {
+	case *bytes.Reader:
+		return int64(r.Len())
+	case *bytes.Buffer:
+		return int64(r.Len())
+	case *strings.Reader:
+		return int64(r.Len())
+	case *os.File:
+		info, err := r.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			return -1
+		}
+		offset, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return -1
+		}
+		return info.Size() - offset
+	}
+	return -1
+}
+
+// progressReader reports the bytes read through it.
+type progressReader struct {
+	r     io.Reader
+	sent  int64
+	total int64
+	fn    func(sent, total int64)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.sent += int64(n)
+		p.fn(p.sent, p.total)
+	}
+	return n, err
 }
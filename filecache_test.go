@@ -1,7 +1,11 @@
 package zeptomail_test
 
 import (
+	"io"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -33,3 +37,71 @@ func TestZeptoMailFileCache(t *testing.T) {
 		assert.NotNil(t, rv.Data.Data)
 	})
 }
+
+func TestFileCacheUpload(t *testing.T) {
+	var (
+		gotBody   []byte
+		gotType   string
+		gotLength int64
+		gotName   string
+	)
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		gotType = r.Header.Get("Content-Type")
+		gotLength = r.ContentLength
+		gotName = r.URL.Query().Get("name")
+		gotBody, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"file_cache_key":"key-1","data":[],"message":"OK","object":"file"}`))
+	})
+	fileCache := (*zeptomail.FileCache)(client)
+
+	t.Run("file", func(t *testing.T) {
+		p := filepath.Join(t.TempDir(), "favicon.ico")
+		require.NoError(t, os.WriteFile(p, fileAttachment, 0o600))
+
+		var progress []int64
+		rv, err := fileCache.UploadFile(t.Context(), p, zeptomail.WithUploadProgress(func(sent, total int64) {
+			assert.EqualValues(t, len(fileAttachment), total)
+			progress = append(progress, sent)
+		}))
+		require.NoError(t, err)
+		assert.Equal(t, "key-1", rv.Data.FileCacheKey)
+
+		assert.Equal(t, fileAttachment, gotBody, "raw bytes are sent")
+		assert.Equal(t, "favicon.ico", gotName)
+		assert.EqualValues(t, len(fileAttachment), gotLength)
+		assert.NotEqual(t, "application/json", gotType)
+		require.NotEmpty(t, progress)
+		assert.EqualValues(t, len(fileAttachment), progress[len(progress)-1])
+	})
+
+	t.Run("reader of unknown length", func(t *testing.T) {
+		r := io.MultiReader(strings.NewReader("%PDF-1.7 "), strings.NewReader("body"))
+		_, err := fileCache.Upload(t.Context(), "doc.pdf", r)
+		require.NoError(t, err)
+		assert.Equal(t, "application/pdf", gotType)
+		assert.Equal(t, "%PDF-1.7 body", string(gotBody))
+		assert.EqualValues(t, -1, gotLength)
+	})
+
+	t.Run("explicit content type", func(t *testing.T) {
+		_, err := fileCache.Upload(t.Context(), "data", strings.NewReader("a,b"), zeptomail.WithContentType("text/csv"))
+		require.NoError(t, err)
+		assert.Equal(t, "text/csv", gotType)
+		assert.EqualValues(t, 3, gotLength)
+	})
+
+	t.Run("upload api sends raw bytes", func(t *testing.T) {
+		_, err := fileCache.FileCacheUploadAPI(t.Context(), zeptomail.FileCacheUploadAPIReq{
+			FileName:    "test_filecache.ico",
+			FileContent: fileAttachment,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, fileAttachment, gotBody)
+	})
+
+	t.Run("blocked file type", func(t *testing.T) {
+		_, err := fileCache.Upload(t.Context(), "setup.exe", strings.NewReader("MZ"))
+		assert.ErrorIs(t, err, zeptomail.ErrBlockedAttachment)
+	})
+}
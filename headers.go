@@ -0,0 +1,157 @@
+package zeptomail
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Headers are the additional MIME headers of an email, by name. Values
+// are strings; other scalar values are sent as their JSON encoding.
+//
+// Header names are case-insensitive: the methods of Headers find a header
+// whatever the case it was set with.
+type Headers map[string]any
+
+// reservedHeaders are set by ZeptoMail from the request, or when the email
+// is delivered, and can't be given as MIME headers.
+var reservedHeaders = map[string]bool{
+	"bcc":                       true,
+	"cc":                        true,
+	"content-disposition":       true,
+	"content-transfer-encoding": true,
+	"content-type":              true,
+	"date":                      true,
+	"dkim-signature":            true,
+	"from":                      true,
+	"message-id":                true,
+	"mime-version":              true,
+	"received":                  true,
+	"reply-to":                  true,
+	"return-path":               true,
+	"sender":                    true,
+	"subject":                   true,
+	"to":                        true,
+}
+
+// HeaderError describes an invalid MIME header.
+type HeaderError struct {
+	Name   string
+	Reason string
+}
+
+func (e *HeaderError) Error() string {
+	return fmt.Sprintf("invalid MIME header %q: %s", e.Name, e.Reason)
+}
+
+// key returns the key name is set with in h, or name if it is not set.
+func (h Headers) key(name string) string {
+	if _, ok := h[name]; ok {
+		return name
+	}
+	for k := range h {
+		if strings.EqualFold(k, name) {
+			return k
+		}
+	}
+	return name
+}
+
+// Get returns the value of the header name, or "" if it is not set.
+func (h Headers) Get(name string) string {
+	v, ok := h[h.key(name)]
+	if !ok || v == nil {
+		return ""
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprint(v)
+}
+
+// Has reports whether the header name is set.
+func (h Headers) Has(name string) bool {
+	_, ok := h[h.key(name)]
+	return ok
+}
+
+// Set sets the header name to value, replacing any value it had.
+func (h Headers) Set(name, value string) {
+	delete(h, h.key(name))
+	h[name] = value
+}
+
+// Add adds value to the header name. A header set more than once has its
+// values joined with commas, the way list headers such as Keywords are
+// written.
+func (h Headers) Add(name, value string) {
+	key := h.key(name)
+	if prev := h.Get(key); prev != "" {
+		value = prev + ", " + value
+	}
+	delete(h, key)
+	h[name] = value
+}
+
+// Del deletes the header name.
+func (h Headers) Del(name string) {
+	delete(h, h.key(name))
+}
+
+// Validate checks the names and values of h, returning the *HeaderError
+// of every invalid header.
+func (h Headers) Validate() error {
+	var errs []error
+	for name, value := range h {
+		if err := ValidateHeader(name, value); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ValidateHeader checks that name is a valid header field name, RFC 5322
+// section 2.2, not reserved by ZeptoMail, and that value can't be used to
+// inject other headers. It returns a *HeaderError if not.
+func ValidateHeader(name string, value any) error {
+	if name == "" {
+		return &HeaderError{Name: name, Reason: "empty name"}
+	}
+	for _, c := range []byte(name) {
+		if c < 33 || c > 126 || c == ':' {
+			return &HeaderError{Name: name, Reason: fmt.Sprintf("invalid character %q in name", c)}
+		}
+	}
+	if reservedHeaders[strings.ToLower(name)] {
+		return &HeaderError{Name: name, Reason: "reserved, set it through the request fields"}
+	}
+
+	var s string
+	switch v := value.(type) {
+	case string:
+		s = v
+	case bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return nil
+	default:
+		return &HeaderError{Name: name, Reason: fmt.Sprintf("value of type %T", value)}
+	}
+	if strings.ContainsAny(s, "\r\n\x00") {
+		return &HeaderError{Name: name, Reason: "value contains CR, LF or NUL"}
+	}
+	return nil
+}
+
+// mimeHeaders returns the MIME headers of req, a pointer to a send request.
+func mimeHeaders(req any) Headers {
+	switch r := req.(type) {
+	case *SendHTMLEmailReq:
+		return r.MimeHeaders
+	case *SendBatchHTMLEmailReq:
+		return r.MimeHeaders
+	case *SendTemplatedEmailReq:
+		return r.MimeHeaders
+	case *SendBatchTemplatedEmailReq:
+		return r.MimeHeaders
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package zeptomail_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/blancsoft/go-zeptomail"
+)
+
+func TestHeaders(t *testing.T) {
+	h := zeptomail.Headers{"X-Tester": "go-zeptomail"}
+	assert.Equal(t, "go-zeptomail", h.Get("x-tester"))
+	assert.True(t, h.Has("X-TESTER"))
+
+	h.Set("x-tester", "other")
+	assert.Equal(t, zeptomail.Headers{"x-tester": "other"}, h, "Set replaces whatever the case")
+
+	h.Add("Keywords", "a")
+	h.Add("keywords", "b")
+	assert.Equal(t, "a, b", h.Get("Keywords"))
+
+	h.Del("KEYWORDS")
+	assert.False(t, h.Has("Keywords"))
+	assert.NoError(t, h.Validate())
+
+	h["X-Count"] = 3
+	assert.NoError(t, h.Validate())
+
+	for name, value := range map[string]any{
+		"X-Evil":     "a\r\nBcc: victim@example.com",
+		"Bad Name":   "x",
+		"X-Colon:":   "x",
+		"Subject":    "x",
+		"message-id": "<1@example.com>",
+		"X-Map":      map[string]any{},
+	} {
+		err := zeptomail.ValidateHeader(name, value)
+		var he *zeptomail.HeaderError
+		require.ErrorAs(t, err, &he, name)
+		assert.Equal(t, name, he.Name)
+	}
+}
+
+func TestSendRejectsInvalidHeaders(t *testing.T) {
+	sends := 0
+	email := (*zeptomail.Email)(newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		sends++
+		acceptHandler(w, r)
+	}))
+
+	_, err := email.SendTemplatedEmail(t.Context(), zeptomail.SendTemplatedEmailReq{
+		BaseSendEmail: zeptomail.BaseSendEmail{
+			From:      sender,
+			To:        []zeptomail.SendEmailTo{{EmailAddress: receiver}},
+			MergeInfo: map[string]any{"name": "Ada"},
+		},
+		BaseEmailOption: zeptomail.BaseEmailOption{
+			MimeHeaders: map[string]any{"X-Ok": "1", "X-Evil": "a\nb"},
+		},
+		TemplateKey: "tpl",
+	})
+	var he *zeptomail.HeaderError
+	require.ErrorAs(t, err, &he)
+	assert.Equal(t, "X-Evil", he.Name)
+	assert.Zero(t, sends)
+
+	_, err = zeptomail.NewMessage().From("a@example.com").To("b@example.com").
+		Subject("s").HTML("<p>x</p>").Header("Reply-To", "c@example.com").BuildHTML()
+	assert.ErrorContains(t, err, "reserved")
+}
@@ -0,0 +1,73 @@
+package zeptomail
+
+import (
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// rewriteTags calls fn for every start tag of body. Tags fn reports as
+// changed are written back from the token, everything else is copied byte
+// for byte, so markup the rewrite is not about is left exactly as it was.
+func rewriteTags(body string, fn func(tok *html.Token) (changed bool, err error)) (string, error) {
+	var (
+		out strings.Builder
+		z   = html.NewTokenizer(strings.NewReader(body))
+	)
+	out.Grow(len(body))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if err := z.Err(); err != io.EOF {
+				return "", err
+			}
+			return out.String(), nil
+		}
+
+		raw := z.Raw()
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			out.Write(raw)
+			continue
+		}
+
+		// Token may reuse the buffer behind raw
+		raw = append([]byte(nil), raw...)
+		tok := z.Token()
+		changed, err := fn(&tok)
+		if err != nil {
+			return "", err
+		}
+		if changed {
+			out.WriteString(tok.String())
+		} else {
+			out.Write(raw)
+		}
+	}
+}
+
+// attr returns the value of the attribute key of tok.
+func attr(tok *html.Token, key string) (string, bool) {
+	for _, a := range tok.Attr {
+		if a.Namespace == "" && strings.EqualFold(a.Key, key) {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+// setAttr sets the attribute key of tok to val.
+func setAttr(tok *html.Token, key, val string) {
+	for i, a := range tok.Attr {
+		if a.Namespace == "" && strings.EqualFold(a.Key, key) {
+			tok.Attr[i].Val = val
+			return
+		}
+	}
+	tok.Attr = append(tok.Attr, html.Attribute{Key: key, Val: val})
+}
+
+// isMergeTag reports whether s holds a merge tag placeholder.
+func isMergeTag(s string) bool {
+	return strings.Contains(s, "{{")
+}
@@ -0,0 +1,407 @@
+package zeptomail
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// IdempotentReplayHeader is set on the RawResponse of a send that was not
+// performed because an identical one already succeeded within the window.
+const IdempotentReplayHeader = "X-Idempotent-Replay"
+
+type (
+	// IdempotencyRecord is the response of a successful send as kept by an
+	// IdempotencyStore.
+	IdempotencyRecord struct {
+		StatusCode int         `json:"status_code"`
+		Header     http.Header `json:"header"`
+		Body       []byte      `json:"body"`
+		CreatedAt  time.Time   `json:"created_at"`
+	}
+
+	// IdempotencyStore persists the responses of sends keyed by their
+	// idempotency key.
+	IdempotencyStore interface {
+		// Get returns the record stored under key, or nil if there is none
+		// or it has expired.
+		Get(ctx context.Context, key string) (*IdempotencyRecord, error)
+
+		// Put stores record under key for at least ttl.
+		Put(ctx context.Context, key string, record IdempotencyRecord, ttl time.Duration) error
+	}
+)
+
+type idempotencyKeyCtx struct{}
+
+// WithIdempotencyKey returns a context that makes a send on an Email with
+// idempotency enabled use key instead of the request's ClientReference.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyCtx{}, key)
+}
+
+// UseIdempotency makes sends sharing an idempotency key within window
+// return the original response instead of reaching ZeptoMail again. The key
+// is the one set with WithIdempotencyKey, or else the request's
+// ClientReference; sends without either are never deduplicated. Keys are
+// scoped to the kind of send, so a single and a batch email sharing a key
+// are both sent.
+//
+// A key must identify a single message: a ClientReference reused across
+// different emails, e.g. to track a campaign, drops every email but the
+// first within window. Set another key with WithIdempotencyKey then.
+//
+// Only successful (2xx) responses are recorded, so failed sends can be
+// retried with the same key.
+func (e *Email) UseIdempotency(store IdempotencyStore, window time.Duration) {
+	e.idempotency = &idempotencyLayer{store: store, window: window}
+}
+
+// clientReferencer is implemented by the send requests carrying a ClientReference.
+type clientReferencer interface {
+	clientReference() string
+}
+
+func (o BaseEmailOption) clientReference() string { return o.ClientReference }
+
+func (r SendBatchTemplatedEmailReq) clientReference() string { return r.ClientReference }
+
+// idempotencyKey returns the key of a send of req to the API path, or ""
+// if it has none.
+func idempotencyKey(ctx context.Context, path string, req any) string {
+	key, _ := ctx.Value(idempotencyKeyCtx{}).(string)
+	if r, ok := req.(clientReferencer); ok && key == "" {
+		key = r.clientReference()
+	}
+	if key == "" {
+		return ""
+	}
+	return path + " " + key
+}
+
+type idempotencyLayer struct {
+	store  IdempotencyStore
+	window time.Duration
+
+	// inflight serialises the sends sharing a key, so that a duplicate
+	// issued while the original is in flight waits for its response
+	// rather than racing it
+	inflight keyedMutex
+}
+
+func idempotent[R any](
+	l *idempotencyLayer, ctx context.Context, key string,
+	do func(context.Context) (*WrappedResponse[R], error),
+) (*WrappedResponse[R], error) {
+	if key == "" {
+		return do(ctx)
+	}
+
+	unlock := l.inflight.Lock(key)
+	defer unlock()
+
+	rec, err := l.store.Get(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("idempotency lookup failed: %w", err)
+	}
+	if rec != nil && time.Since(rec.CreatedAt) < l.window {
+		return replay[R](rec)
+	}
+
+	rv, err := do(ctx)
+	if err != nil || rv.RawResponse.StatusCode < 200 || rv.RawResponse.StatusCode > 299 {
+		return rv, err
+	}
+
+	body, err := io.ReadAll(rv.RawResponse.Body)
+	if err != nil {
+		return rv, fmt.Errorf("reading response failed: %w", err)
+	}
+	rv.RawResponse.Body = io.NopCloser(bytes.NewReader(body))
+
+	rec = &IdempotencyRecord{
+		StatusCode: rv.RawResponse.StatusCode,
+		Header:     rv.RawResponse.Header.Clone(),
+		Body:       body,
+		CreatedAt:  time.Now(),
+	}
+	if err = l.store.Put(ctx, key, *rec, l.window); err != nil {
+		// the email has been sent, so the response is returned all the same
+		return rv, fmt.Errorf("idempotency record failed: %w", err)
+	}
+	return rv, nil
+}
+
+// replay rebuilds the response of a recorded send.
+func replay[R any](rec *IdempotencyRecord) (*WrappedResponse[R], error) {
+	header := rec.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	header.Set(IdempotentReplayHeader, "true")
+
+	var rv WrappedResponse[R]
+	rv.RawResponse = &http.Response{
+		Status:        fmt.Sprintf("%d %s", rec.StatusCode, http.StatusText(rec.StatusCode)),
+		StatusCode:    rec.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		ContentLength: int64(len(rec.Body)),
+		Body:          io.NopCloser(bytes.NewReader(rec.Body)),
+	}
+	if err := json.Unmarshal(rec.Body, &rv.Data); err != nil && len(rec.Body) > 0 {
+		return &rv, fmt.Errorf("decoding failed: %w", err)
+	}
+	return &rv, nil
+}
+
+// MemoryIdempotencyStore is an IdempotencyStore holding at most a fixed
+// number of records in memory, evicting the least recently used first.
+type MemoryIdempotencyStore struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+type memoryIdempotencyEntry struct {
+	key       string
+	record    IdempotencyRecord
+	expiresAt time.Time
+}
+
+// NewMemoryIdempotencyStore returns a MemoryIdempotencyStore holding up to
+// capacity records. A capacity of zero or less means no limit.
+func NewMemoryIdempotencyStore(capacity int) *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// Get implements IdempotencyStore.
+func (s *MemoryIdempotencyStore) Get(_ context.Context, key string) (*IdempotencyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.entries[key]
+	if !ok {
+		return nil, nil
+	}
+	entry := el.Value.(*memoryIdempotencyEntry)
+	if time.Now().After(entry.expiresAt) {
+		s.order.Remove(el)
+		delete(s.entries, key)
+		return nil, nil
+	}
+	s.order.MoveToFront(el)
+	rec := entry.record
+	return &rec, nil
+}
+
+// Put implements IdempotencyStore.
+func (s *MemoryIdempotencyStore) Put(_ context.Context, key string, record IdempotencyRecord, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry := &memoryIdempotencyEntry{key: key, record: record, expiresAt: time.Now().Add(ttl)}
+	if el, ok := s.entries[key]; ok {
+		el.Value = entry
+		s.order.MoveToFront(el)
+		return nil
+	}
+	s.entries[key] = s.order.PushFront(entry)
+	for s.capacity > 0 && s.order.Len() > s.capacity {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(*memoryIdempotencyEntry).key)
+	}
+	return nil
+}
+
+// Len returns the number of records held, including expired ones not yet evicted.
+func (s *MemoryIdempotencyStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.order.Len()
+}
+
+// FileIdempotencyStore is an IdempotencyStore keeping one JSON file per key
+// in a directory, so records survive restarts of the process.
+type FileIdempotencyStore struct {
+	dir string
+}
+
+type fileIdempotencyEntry struct {
+	Key       string            `json:"key"`
+	Record    IdempotencyRecord `json:"record"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+// NewFileIdempotencyStore returns a FileIdempotencyStore writing to dir,
+// creating it if needed.
+func NewFileIdempotencyStore(dir string) (*FileIdempotencyStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &FileIdempotencyStore{dir: dir}, nil
+}
+
+func (s *FileIdempotencyStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// Get implements IdempotencyStore.
+func (s *FileIdempotencyStore) Get(_ context.Context, key string) (*IdempotencyRecord, error) {
+	b, err := os.ReadFile(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entry fileIdempotencyEntry
+	if err = json.Unmarshal(b, &entry); err != nil {
+		return nil, fmt.Errorf("decoding %s failed: %w", s.path(key), err)
+	}
+	if entry.Key != key || time.Now().After(entry.ExpiresAt) {
+		return nil, nil
+	}
+	return &entry.Record, nil
+}
+
+// Put implements IdempotencyStore.
+func (s *FileIdempotencyStore) Put(_ context.Context, key string, record IdempotencyRecord, ttl time.Duration) error {
+	b, err := json.Marshal(fileIdempotencyEntry{Key: key, Record: record, ExpiresAt: time.Now().Add(ttl)})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path(key), b)
+}
+
+// Prune removes the files of expired records.
+func (s *FileIdempotencyStore) Prune() error {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, p := range paths {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			continue
+		}
+		var entry fileIdempotencyEntry
+		if json.Unmarshal(b, &entry) == nil && time.Now().After(entry.ExpiresAt) {
+			if err = os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeFileAtomic replaces the file at path with data, so readers never
+// observe a partially written file.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+
+	if _, err = f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// SQLIdempotencyStore is an IdempotencyStore backed by a database table,
+// which lets several processes share their records. The table must have
+// the following columns:
+//
+//	CREATE TABLE zeptomail_idempotency (
+//		idempotency_key VARCHAR(255) PRIMARY KEY,
+//		record          TEXT   NOT NULL,
+//		expires_at      BIGINT NOT NULL
+//	)
+type SQLIdempotencyStore struct {
+	table sqlTable
+}
+
+// NewSQLIdempotencyStore returns a SQLIdempotencyStore using the given
+// table. Statements bind arguments with QuestionPlaceholder unless another
+// Placeholder is given.
+func NewSQLIdempotencyStore(db *sql.DB, table string, placeholder ...Placeholder) (*SQLIdempotencyStore, error) {
+	t, err := newSQLTable(db, table, placeholder)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLIdempotencyStore{table: t}, nil
+}
+
+// Get implements IdempotencyStore.
+func (s *SQLIdempotencyStore) Get(ctx context.Context, key string) (*IdempotencyRecord, error) {
+	var (
+		raw       string
+		expiresAt int64
+	)
+	q := s.table.query("SELECT record, expires_at FROM %s WHERE idempotency_key = %s", 1)
+	err := s.table.db.QueryRowContext(ctx, q, key).Scan(&raw, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().UnixNano() > expiresAt {
+		return nil, nil
+	}
+
+	var rec IdempotencyRecord
+	if err = json.Unmarshal([]byte(raw), &rec); err != nil {
+		return nil, fmt.Errorf("decoding record failed: %w", err)
+	}
+	return &rec, nil
+}
+
+// Put implements IdempotencyStore.
+func (s *SQLIdempotencyStore) Put(ctx context.Context, key string, record IdempotencyRecord, ttl time.Duration) error {
+	raw, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return s.table.replace(ctx,
+		s.table.query("DELETE FROM %s WHERE idempotency_key = %s", 1), []any{key},
+		s.table.query("INSERT INTO %s (idempotency_key, record, expires_at) VALUES (%s, %s, %s)", 3),
+		[]any{key, string(raw), time.Now().Add(ttl).UnixNano()},
+	)
+}
+
+// Prune deletes the expired records.
+func (s *SQLIdempotencyStore) Prune(ctx context.Context) error {
+	q := s.table.query("DELETE FROM %s WHERE expires_at < %s", 1)
+	_, err := s.table.db.ExecContext(ctx, q, time.Now().UnixNano())
+	return err
+}
@@ -0,0 +1,187 @@
+package zeptomail_test
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/blancsoft/go-zeptomail"
+)
+
+func TestIdempotency(t *testing.T) {
+	var calls atomic.Int32
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		acceptHandler(w, r)
+	})
+	email := (*zeptomail.Email)(client)
+	email.UseIdempotency(zeptomail.NewMemoryIdempotencyStore(10), time.Minute)
+
+	req := zeptomail.SendHTMLEmailReq{
+		BaseSendEmail: zeptomail.BaseSendEmail{
+			From:      sender,
+			To:        []zeptomail.SendEmailTo{{EmailAddress: receiver}},
+			MergeInfo: map[string]any{"name": "World"},
+		},
+		BaseEmailOption: zeptomail.BaseEmailOption{ClientReference: "receipt-1"},
+		Subject:         emailSubject,
+		HtmlBody:        emailBody,
+	}
+
+	t.Run("duplicate is replayed", func(t *testing.T) {
+		first, err := email.SendHTMLEmail(t.Context(), req)
+		require.NoError(t, err)
+		second, err := email.SendHTMLEmail(t.Context(), req)
+		require.NoError(t, err)
+
+		assert.EqualValues(t, 1, calls.Load())
+		assert.Equal(t, first.Data, second.Data)
+		assert.Equal(t, http.StatusCreated, second.RawResponse.StatusCode)
+		assert.Equal(t, "true", second.RawResponse.Header.Get(zeptomail.IdempotentReplayHeader))
+		assert.Empty(t, first.RawResponse.Header.Get(zeptomail.IdempotentReplayHeader))
+	})
+
+	t.Run("explicit key", func(t *testing.T) {
+		calls.Store(0)
+		ctx := zeptomail.WithIdempotencyKey(t.Context(), "explicit")
+		_, err := email.SendHTMLEmail(ctx, req)
+		require.NoError(t, err)
+		_, err = email.SendHTMLEmail(ctx, req)
+		require.NoError(t, err)
+		assert.EqualValues(t, 1, calls.Load())
+	})
+
+	t.Run("concurrent duplicates", func(t *testing.T) {
+		calls.Store(0)
+		ctx := zeptomail.WithIdempotencyKey(t.Context(), "concurrent")
+		var wg sync.WaitGroup
+		for range 8 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := email.SendHTMLEmail(ctx, req)
+				assert.NoError(t, err)
+			}()
+		}
+		wg.Wait()
+		assert.EqualValues(t, 1, calls.Load())
+	})
+
+	t.Run("keys are scoped to the kind of send", func(t *testing.T) {
+		calls.Store(0)
+		_, err := email.SendTemplatedEmail(t.Context(), zeptomail.SendTemplatedEmailReq{
+			BaseSendEmail:   req.BaseSendEmail,
+			BaseEmailOption: req.BaseEmailOption,
+			TemplateKey:     "tmpl",
+		})
+		require.NoError(t, err)
+		assert.EqualValues(t, 1, calls.Load(), "receipt-1 was only used for single HTML emails")
+	})
+
+	t.Run("without key", func(t *testing.T) {
+		calls.Store(0)
+		req := req
+		req.ClientReference = ""
+		for range 2 {
+			_, err := email.SendHTMLEmail(t.Context(), req)
+			require.NoError(t, err)
+		}
+		assert.EqualValues(t, 2, calls.Load())
+	})
+}
+
+func TestIdempotencyFailureNotRecorded(t *testing.T) {
+	var calls atomic.Int32
+	client := newTestClient(t, func(w http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":{"code":"GE_102","message":"Internal error"}}`))
+	})
+	email := (*zeptomail.Email)(client)
+	email.UseIdempotency(zeptomail.NewMemoryIdempotencyStore(10), time.Minute)
+
+	ctx := zeptomail.WithIdempotencyKey(t.Context(), "failing")
+	req := zeptomail.SendTemplatedEmailReq{
+		BaseSendEmail: zeptomail.BaseSendEmail{
+			From:      sender,
+			To:        []zeptomail.SendEmailTo{{EmailAddress: receiver}},
+			MergeInfo: map[string]any{"name": "World"},
+		},
+		TemplateKey: "tmpl",
+	}
+	for range 2 {
+		rv, err := email.SendTemplatedEmail(ctx, req)
+		require.NoError(t, err)
+		assert.Equal(t, "GE_102", rv.Data.Error.Code)
+	}
+	assert.EqualValues(t, 2, calls.Load())
+}
+
+func TestIdempotencyStores(t *testing.T) {
+	rec := zeptomail.IdempotencyRecord{StatusCode: http.StatusCreated, Body: []byte(sendAccepted), CreatedAt: time.Now()}
+
+	fileStore, err := zeptomail.NewFileIdempotencyStore(t.TempDir())
+	require.NoError(t, err)
+
+	db := newTestDB(t, `CREATE TABLE zeptomail_idempotency (
+		idempotency_key VARCHAR(255) PRIMARY KEY,
+		record          TEXT   NOT NULL,
+		expires_at      BIGINT NOT NULL
+	)`)
+	sqlStore, err := zeptomail.NewSQLIdempotencyStore(db, "zeptomail_idempotency")
+	require.NoError(t, err)
+
+	stores := map[string]zeptomail.IdempotencyStore{
+		"memory": zeptomail.NewMemoryIdempotencyStore(0),
+		"file":   fileStore,
+		"sql":    sqlStore,
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			got, err := store.Get(ctx, "missing")
+			require.NoError(t, err)
+			assert.Nil(t, got)
+
+			require.NoError(t, store.Put(ctx, "key", rec, time.Minute))
+			got, err = store.Get(ctx, "key")
+			require.NoError(t, err)
+			require.NotNil(t, got)
+			assert.Equal(t, rec.StatusCode, got.StatusCode)
+			assert.Equal(t, rec.Body, got.Body)
+
+			require.NoError(t, store.Put(ctx, "expired", rec, -time.Second))
+			got, err = store.Get(ctx, "expired")
+			require.NoError(t, err)
+			assert.Nil(t, got)
+		})
+	}
+
+	t.Run("sql prune", func(t *testing.T) {
+		require.NoError(t, sqlStore.Prune(t.Context()))
+		var n int
+		require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM zeptomail_idempotency").Scan(&n))
+		assert.Equal(t, 1, n, "only the expired record is deleted")
+	})
+
+	t.Run("lru eviction", func(t *testing.T) {
+		ctx := context.Background()
+		store := zeptomail.NewMemoryIdempotencyStore(2)
+		require.NoError(t, store.Put(ctx, "a", rec, time.Minute))
+		require.NoError(t, store.Put(ctx, "b", rec, time.Minute))
+		_, _ = store.Get(ctx, "a") // a becomes the most recently used
+		require.NoError(t, store.Put(ctx, "c", rec, time.Minute))
+
+		assert.Equal(t, 2, store.Len())
+		got, _ := store.Get(ctx, "b")
+		assert.Nil(t, got)
+		got, _ = store.Get(ctx, "a")
+		assert.NotNil(t, got)
+	})
+}
@@ -0,0 +1,192 @@
+package zeptomail
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// EmbedInlineImages embeds the images the <img> tags of body load from
+// local sources, and rewrites their src to refer to the embedded image by
+// its CID. Sources can be
+//
+//   - data: URIs,
+//   - relative paths, and file: URLs whose path is taken relative to the
+//     root of fsys, such as os.DirFS of the template directory.
+//
+// Files are only ever read through fsys: when it is nil, only data: URIs
+// are embedded and a file source is an error. Absolute paths and paths
+// with ".." elements are rejected.
+//
+// Remote (http, https), cid: and merge tag sources are left as they are.
+// Identical images share a CID derived from their content, so an image
+// referenced several times is embedded once.
+func EmbedInlineImages(body string, fsys fs.FS) (string, []InlineImage, error) {
+	var (
+		images []InlineImage
+		seen   = make(map[string]bool)
+	)
+	rewritten, err := rewriteTags(body, func(tok *html.Token) (bool, error) {
+		if tok.Data != "img" {
+			return false, nil
+		}
+		src, ok := attr(tok, "src")
+		if !ok || !isLocalImage(src) {
+			return false, nil
+		}
+
+		img, err := loadInlineImage(src, fsys)
+		if err != nil {
+			return false, err
+		}
+		if !seen[img.Cid] {
+			seen[img.Cid] = true
+			images = append(images, img)
+		}
+		setAttr(tok, "src", "cid:"+img.Cid)
+		return true, nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return rewritten, images, nil
+}
+
+// EmbedImages embeds the local images of HtmlBody with EmbedInlineImages,
+// adding them to InlineImages.
+func (r *SendHTMLEmailReq) EmbedImages(fsys fs.FS) error {
+	body, images, err := EmbedInlineImages(r.HtmlBody, fsys)
+	if err != nil {
+		return err
+	}
+	r.HtmlBody = body
+	r.InlineImages = append(r.InlineImages, images...)
+	return nil
+}
+
+func isLocalImage(src string) bool {
+	src = strings.TrimSpace(src)
+	if src == "" || isMergeTag(src) || strings.HasPrefix(src, "//") {
+		return false
+	}
+	u, err := url.Parse(src)
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "", "data", "file":
+		return true
+	}
+	return false
+}
+
+// imagePath returns the name in an fs.FS of the image file at src, a
+// relative path or a file: URL.
+func imagePath(src string) (string, error) {
+	name := src
+	if strings.HasPrefix(strings.ToLower(src), "file:") {
+		u, err := url.Parse(src)
+		if err != nil {
+			return "", err
+		}
+		if u.Host != "" && u.Host != "localhost" {
+			return "", fmt.Errorf("remote file host %q", u.Host)
+		}
+		name = strings.TrimPrefix(u.Path, "/")
+	} else if path.IsAbs(name) || filepath.IsAbs(name) || strings.HasPrefix(name, `\`) {
+		return "", errors.New("absolute paths are not allowed")
+	}
+
+	for _, elem := range strings.FieldsFunc(name, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if elem == ".." {
+			return "", errors.New(`paths with ".." are not allowed`)
+		}
+	}
+	name = path.Clean(name)
+	if !fs.ValidPath(name) {
+		return "", fmt.Errorf("invalid path %q", name)
+	}
+	return name, nil
+}
+
+func loadInlineImage(src string, fsys fs.FS) (InlineImage, error) {
+	src = strings.TrimSpace(src)
+
+	var (
+		data     []byte
+		mimeType string
+		err      error
+	)
+	switch {
+	case strings.HasPrefix(strings.ToLower(src), "data:"):
+		mimeType, data, err = decodeDataURI(src)
+	case fsys == nil:
+		err = errors.New("no file system to read local images from")
+	default:
+		var name string
+		if name, err = imagePath(src); err == nil {
+			data, err = fs.ReadFile(fsys, name)
+		}
+	}
+	if err != nil {
+		return InlineImage{}, fmt.Errorf("loading image %q failed: %w", truncate(src, 64), err)
+	}
+	if len(data) > MaxAttachmentSize {
+		return InlineImage{}, fmt.Errorf("image %q: %w", truncate(src, 64), ErrAttachmentTooLarge)
+	}
+	if mimeType == "" {
+		mimeType = DetectContentType(src, data)
+	}
+
+	sum := sha256.Sum256(data)
+	return InlineImage{
+		MimeType: mimeType,
+		Content:  base64.StdEncoding.EncodeToString(data),
+		Cid:      "img-" + hex.EncodeToString(sum[:8]),
+	}, nil
+}
+
+// decodeDataURI decodes a data: URI as described by RFC 2397.
+func decodeDataURI(uri string) (string, []byte, error) {
+	meta, payload, ok := strings.Cut(uri[len("data:"):], ",")
+	if !ok {
+		return "", nil, fmt.Errorf("malformed data URI")
+	}
+
+	isBase64 := false
+	if m, found := strings.CutSuffix(meta, ";base64"); found {
+		meta, isBase64 = m, true
+	}
+	mimeType := "text/plain"
+	if meta != "" {
+		mediaType, _, err := mime.ParseMediaType(meta)
+		if err != nil {
+			return "", nil, err
+		}
+		mimeType = mediaType
+	}
+
+	if isBase64 {
+		data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(payload), ""))
+		return mimeType, data, err
+	}
+	text, err := url.PathUnescape(payload)
+	return mimeType, []byte(text), err
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "…"
+}
@@ -0,0 +1,108 @@
+package zeptomail_test
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/blancsoft/go-zeptomail"
+)
+
+func TestEmbedInlineImages(t *testing.T) {
+	png := append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 16)...)
+	fsys := fstest.MapFS{
+		"images/logo.png": {Data: png},
+		"images/icon":     {Data: fileAttachment},
+	}
+	dataURI := "data:image/gif;base64," + base64.StdEncoding.EncodeToString([]byte("GIF89a"))
+
+	body := `<p>Hi {{name}}</p>` +
+		`<img src="images/logo.png" alt="Logo">` +
+		`<img alt="again" src="file:///images/logo.png"/>` +
+		`<img src="` + dataURI + `">` +
+		`<img src="images/icon" width=16>` +
+		`<img src="https://cdn.example.com/banner.png">` +
+		`<img src="{{avatar}}">` +
+		`<img src="cid:existing">`
+
+	got, images, err := zeptomail.EmbedInlineImages(body, fsys)
+	require.NoError(t, err)
+	require.Len(t, images, 3, "the logo is embedded once")
+
+	logo, gif, icon := images[0], images[1], images[2]
+	assert.Equal(t, "image/png", logo.MimeType)
+	assert.Equal(t, base64.StdEncoding.EncodeToString(png), logo.Content)
+	assert.Equal(t, "image/gif", gif.MimeType)
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("GIF89a")), gif.Content)
+	assert.Equal(t, base64.StdEncoding.EncodeToString(fileAttachment), icon.Content)
+
+	assert.Equal(t, `<p>Hi {{name}}</p>`+
+		`<img src="cid:`+logo.Cid+`" alt="Logo">`+
+		`<img alt="again" src="cid:`+logo.Cid+`"/>`+
+		`<img src="cid:`+gif.Cid+`">`+
+		`<img src="cid:`+icon.Cid+`" width="16">`+
+		`<img src="https://cdn.example.com/banner.png">`+
+		`<img src="{{avatar}}">`+
+		`<img src="cid:existing">`, got)
+
+	t.Run("missing file", func(t *testing.T) {
+		_, _, err := zeptomail.EmbedInlineImages(`<img src="nope.png">`, fsys)
+		assert.ErrorContains(t, err, "nope.png")
+	})
+
+	t.Run("os directory and request helper", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "logo.png"), png, 0o600))
+
+		req := zeptomail.SendHTMLEmailReq{HtmlBody: `<img src="logo.png">`}
+		require.NoError(t, req.EmbedImages(os.DirFS(dir)))
+		require.Len(t, req.InlineImages, 1)
+		assert.True(t, strings.HasPrefix(req.HtmlBody, `<img src="cid:img-`))
+		assert.Equal(t, logo.Cid, req.InlineImages[0].Cid, "cid is derived from the content")
+	})
+
+	t.Run("files are only read through fsys", func(t *testing.T) {
+		p := filepath.Join(t.TempDir(), "logo.png")
+		require.NoError(t, os.WriteFile(p, png, 0o600))
+
+		for _, src := range []string{p, "logo.png", "file://" + filepath.ToSlash(p)} {
+			_, _, err := zeptomail.EmbedInlineImages(`<img src="`+src+`">`, nil)
+			assert.ErrorContains(t, err, "no file system", src)
+		}
+		_, _, err := zeptomail.EmbedInlineImages(`<img src="file://`+filepath.ToSlash(p)+`">`, fsys)
+		assert.ErrorContains(t, err, "file does not exist", "file: URLs are resolved in fsys")
+
+		_, images, err := zeptomail.EmbedInlineImages(`<img src="data:image/gif;base64,R0lGODlh">`, nil)
+		require.NoError(t, err)
+		assert.Len(t, images, 1, "data: URIs need no file system")
+	})
+
+	t.Run("unsafe paths", func(t *testing.T) {
+		for _, src := range []string{
+			"/etc/passwd",
+			`\\server\share\logo.png`,
+			"../../secrets/logo.png",
+			"images/../../logo.png",
+			`images\..\..\logo.png`,
+			"file:///../logo.png",
+			"file://remote/images/logo.png",
+		} {
+			_, _, err := zeptomail.EmbedInlineImages(`<img src="`+src+`">`, fsys)
+			assert.Error(t, err, src)
+		}
+	})
+
+	t.Run("other schemes are left as they are", func(t *testing.T) {
+		body := `<img src="c:/images/logo.png"><img src="http://[::1">`
+		got, images, err := zeptomail.EmbedInlineImages(body, fsys)
+		require.NoError(t, err)
+		assert.Empty(t, images)
+		assert.Equal(t, body, got)
+	})
+}
@@ -0,0 +1,40 @@
+package zeptomail
+
+import "sync"
+
+// keyedMutex is a set of mutexes created on demand for each key. The zero
+// value is ready to use.
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	sync.Mutex
+	refs int
+}
+
+// Lock locks the mutex of key and returns the function unlocking it.
+func (m *keyedMutex) Lock(key string) (unlock func()) {
+	m.mu.Lock()
+	if m.locks == nil {
+		m.locks = make(map[string]*keyLock)
+	}
+	kl, ok := m.locks[key]
+	if !ok {
+		kl = &keyLock{}
+		m.locks[key] = kl
+	}
+	kl.refs++
+	m.mu.Unlock()
+
+	kl.Lock()
+	return func() {
+		kl.Unlock()
+		m.mu.Lock()
+		if kl.refs--; kl.refs == 0 {
+			delete(m.locks, key)
+		}
+		m.mu.Unlock()
+	}
+}
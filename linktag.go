@@ -0,0 +1,129 @@
+package zeptomail
+
+import (
+	"context"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// LinkTagConfig configures link tagging, see TagLinks.
+type LinkTagConfig struct {
+	// Links to these domains, or their subdomains, are tagged
+	Domains []string
+
+	// Query parameters added to the links, e.g. utm_source, utm_medium and
+	// utm_campaign
+	Params map[string]string
+
+	// The query parameter identifying the message, e.g. utm_content. Its
+	// value is the ClientReference of the message; it is left out if
+	// either is empty.
+	MessageParam string
+
+	// Tells unsubscribe links, which are left as they are. By default,
+	// links containing "unsubscribe".
+	IsUnsubscribe func(href string) bool
+}
+
+// TagLinks adds the query parameters of cfg to the href of the links of
+// body pointing to its domains. messageID is the value of
+// cfg.MessageParam.
+//
+// Links that already have a parameter keep their value, so tagging a body
+// twice changes nothing. mailto: links, unsubscribe links and hrefs holding
+// merge tags are left as they are.
+func TagLinks(body string, cfg LinkTagConfig, messageID string) (string, error) {
+	params := make([]string, 0, len(cfg.Params)+1)
+	for k := range cfg.Params {
+		params = append(params, k)
+	}
+	slices.Sort(params)
+	values := cfg.Params
+	if cfg.MessageParam != "" && messageID != "" {
+		params = append(params, cfg.MessageParam)
+		values = maps.Clone(cfg.Params)
+		if values == nil {
+			values = make(map[string]string, 1)
+		}
+		values[cfg.MessageParam] = messageID
+	}
+	if len(params) == 0 {
+		return body, nil
+	}
+
+	isUnsubscribe := cfg.IsUnsubscribe
+	if isUnsubscribe == nil {
+		isUnsubscribe = func(href string) bool {
+			return strings.Contains(strings.ToLower(href), "unsubscribe")
+		}
+	}
+
+	return rewriteTags(body, func(tok *html.Token) (bool, error) {
+		if tok.Data != "a" && tok.Data != "area" {
+			return false, nil
+		}
+		href, ok := attr(tok, "href")
+		if !ok || isMergeTag(href) || isUnsubscribe(href) {
+			return false, nil
+		}
+		u, err := url.Parse(strings.TrimSpace(href))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || !matchesDomain(u.Hostname(), cfg.Domains) {
+			return false, nil
+		}
+
+		query := u.Query()
+		added := u.RawQuery
+		for _, k := range params {
+			if query.Has(k) {
+				continue
+			}
+			if added != "" {
+				added += "&"
+			}
+			added += url.QueryEscape(k) + "=" + url.QueryEscape(values[k])
+		}
+		if added == u.RawQuery {
+			return false, nil
+		}
+		u.RawQuery = added
+		setAttr(tok, "href", u.String())
+		return true, nil
+	})
+}
+
+// LinkTagger returns a Transformer tagging the links of HTML bodies with
+// TagLinks, identifying messages by their ClientReference.
+func LinkTagger(cfg LinkTagConfig) Transformer {
+	return func(_ context.Context, msg *OutgoingMessage) error {
+		if msg.HtmlBody == "" {
+			return nil
+		}
+		body, err := TagLinks(msg.HtmlBody, cfg, msg.ClientReference)
+		if err != nil {
+			return err
+		}
+		msg.HtmlBody = body
+		return nil
+	}
+}
+
+// UseLinkTagging adds a LinkTagger to the transformers of e.
+func (e *Email) UseLinkTagging(cfg LinkTagConfig) {
+	e.UseTransformers(LinkTagger(cfg))
+}
+
+// matchesDomain reports whether host is one of domains or a subdomain of one.
+func matchesDomain(host string, domains []string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, d := range domains {
+		d = strings.ToLower(strings.TrimSuffix(d, "."))
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
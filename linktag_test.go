@@ -0,0 +1,70 @@
+package zeptomail_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/blancsoft/go-zeptomail"
+)
+
+func TestTagLinks(t *testing.T) {
+	cfg := zeptomail.LinkTagConfig{
+		Domains:      []string{"example.com"},
+		Params:       map[string]string{"utm_source": "zeptomail", "utm_medium": "email", "utm_campaign": "welcome"},
+		MessageParam: "utm_content",
+	}
+	body := `<a href="https://example.com/start">Start</a>` +
+		`<a class="x" href="https://www.example.com/p?id=1&utm_source=partner#top">Product</a>` +
+		`<a href="https://other.com/">Other</a>` +
+		`<a href="mailto:help@example.com">Help</a>` +
+		`<a href="https://example.com/unsubscribe?u=1">Unsubscribe</a>` +
+		`<a href="https://example.com/{{path}}">Merge</a>` +
+		`<a href="/relative">Relative</a>`
+
+	got, err := zeptomail.TagLinks(body, cfg, "order 42")
+	require.NoError(t, err)
+	assert.Equal(t,
+		`<a href="https://example.com/start?utm_campaign=welcome&amp;utm_medium=email&amp;utm_source=zeptomail&amp;utm_content=order+42">Start</a>`+
+			`<a class="x" href="https://www.example.com/p?id=1&amp;utm_source=partner&amp;utm_campaign=welcome&amp;utm_medium=email&amp;utm_content=order+42#top">Product</a>`+
+			`<a href="https://other.com/">Other</a>`+
+			`<a href="mailto:help@example.com">Help</a>`+
+			`<a href="https://example.com/unsubscribe?u=1">Unsubscribe</a>`+
+			`<a href="https://example.com/{{path}}">Merge</a>`+
+			`<a href="/relative">Relative</a>`, got)
+
+	again, err := zeptomail.TagLinks(got, cfg, "order 42")
+	require.NoError(t, err)
+	assert.Equal(t, got, again, "tagging is idempotent")
+
+	t.Run("transformer", func(t *testing.T) {
+		msg := &zeptomail.OutgoingMessage{
+			HtmlBody:        `<a href="https://example.com">Home</a>`,
+			ClientReference: "ref-1",
+		}
+		require.NoError(t, zeptomail.LinkTagger(cfg)(context.Background(), msg))
+		assert.Contains(t, msg.HtmlBody, "utm_content=ref-1")
+	})
+}
+
+func TestUseLinkTagging(t *testing.T) {
+	var sent map[string]any
+	email := (*zeptomail.Email)(newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&sent))
+		acceptHandler(w, r)
+	}))
+	email.UseLinkTagging(zeptomail.LinkTagConfig{
+		Domains: []string{"example.com"},
+		Params:  map[string]string{"utm_source": "zeptomail"},
+	})
+
+	msg := dispatchMsg(emailSubject)
+	msg.HtmlBody = `<a href="https://example.com/start">Start</a>`
+	_, err := email.SendHTMLEmail(t.Context(), msg)
+	require.NoError(t, err)
+	assert.Equal(t, `<a href="https://example.com/start?utm_source=zeptomail">Start</a>`, sent["htmlbody"])
+}
@@ -0,0 +1,228 @@
+package zeptomail
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MergeTagError lists the merge tags of an email that its merge info has no
+// value for.
+type MergeTagError struct {
+	// The template key, empty for HTML emails
+	TemplateKey string
+
+	// The recipient of a batch email the merge info is for
+	Recipient string
+
+	// First segments of the unresolved tags, e.g. "user" for {{user.name}}
+	Missing []string
+
+	// Keys of the merge info that look like misspellings of a missing key,
+	// by the key they are likely meant to be
+	Misspelled map[string]string
+}
+
+func (e *MergeTagError) Error() string {
+	var b strings.Builder
+	b.WriteString("merge info")
+	if e.TemplateKey != "" {
+		fmt.Fprintf(&b, " for template %q", e.TemplateKey)
+	}
+	if e.Recipient != "" {
+		fmt.Fprintf(&b, " of %s", e.Recipient)
+	}
+	b.WriteString(" is missing ")
+	b.WriteString(strings.Join(e.Missing, ", "))
+	for _, missing := range e.Missing {
+		if given, ok := e.Misspelled[missing]; ok {
+			fmt.Fprintf(&b, "; %q given instead of %q", given, missing)
+		}
+	}
+	return b.String()
+}
+
+// LintMergeInfo checks that mergeInfo has a value for every merge tag of
+// tmpl, as returned by Template.GetEmailTemplate. It returns a
+// *MergeTagError if it does not.
+func LintMergeInfo(tmpl *GetEmailTemplateRes, mergeInfo map[string]any) error {
+	c := MergeContent{
+		Subject:  tmpl.Data.Subject,
+		HtmlBody: tmpl.Data.HtmlBody,
+		TextBody: tmpl.Data.TextBody,
+	}
+	err := lintMergeContent(c, mergeInfo)
+	if err != nil {
+		if mte, ok := err.(*MergeTagError); ok {
+			mte.TemplateKey = tmpl.Data.TemplateKey
+		}
+	}
+	return err
+}
+
+func lintMergeContent(c MergeContent, mergeInfo map[string]any) error {
+	rendered, err := c.Render(mergeInfo)
+	if err != nil {
+		return err
+	}
+	if len(rendered.Unresolved) == 0 {
+		return nil
+	}
+
+	rv := &MergeTagError{}
+	for _, name := range rendered.Unresolved {
+		root, _, _ := strings.Cut(name, ".")
+		if !slices.Contains(rv.Missing, root) {
+			rv.Missing = append(rv.Missing, root)
+		}
+	}
+	for _, missing := range rv.Missing {
+		if given := closestKey(missing, rendered.Unused); given != "" {
+			if rv.Misspelled == nil {
+				rv.Misspelled = make(map[string]string)
+			}
+			rv.Misspelled[missing] = given
+		}
+	}
+	return rv
+}
+
+// closestKey returns the key of candidates most likely to be a misspelling
+// of name, if any is close enough.
+func closestKey(name string, candidates []string) string {
+	var (
+		best     string
+		bestDist = len(name)/3 + 1
+	)
+	for _, c := range candidates {
+		if strings.EqualFold(c, name) {
+			return c
+		}
+		if d := levenshtein(strings.ToLower(c), strings.ToLower(name)); d < bestDist {
+			best, bestDist = c, d
+		}
+	}
+	return best
+}
+
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+type mergeTagLint struct {
+	templates *Template
+	ttl       time.Duration
+
+	mu     sync.Mutex
+	cached map[string]cachedTemplate
+}
+
+type cachedTemplate struct {
+	tmpl      *GetEmailTemplateRes
+	fetchedAt time.Time
+}
+
+// UseMergeTagLint makes every send check its merge info against the merge
+// tags of the email before the API is called, failing with a
+// *MergeTagError if a tag has no value. Templates are fetched with
+// templates, which needs a client authorized for the template API, and
+// cached for ttl; a ttl of 0 caches them for the life of e.
+func (e *Email) UseMergeTagLint(templates *Template, ttl time.Duration) {
+	e.mergeLint = &mergeTagLint{
+		templates: templates,
+		ttl:       ttl,
+		cached:    make(map[string]cachedTemplate),
+	}
+}
+
+// template returns the template key, from the cache if it is fresh.
+func (l *mergeTagLint) template(ctx context.Context, key string) (*GetEmailTemplateRes, error) {
+	l.mu.Lock()
+	c, ok := l.cached[key]
+	l.mu.Unlock()
+	if ok && (l.ttl <= 0 || time.Since(c.fetchedAt) < l.ttl) {
+		return c.tmpl, nil
+	}
+
+	res, err := l.templates.GetEmailTemplate(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("fetching template %q for linting failed: %w", key, err)
+	}
+	if res.Data.Error != nil {
+		return nil, fmt.Errorf("fetching template %q for linting failed: %s", key, res.Data.Error.Message)
+	}
+	tmpl := &res.Data
+
+	l.mu.Lock()
+	l.cached[key] = cachedTemplate{tmpl: tmpl, fetchedAt: time.Now()}
+	l.mu.Unlock()
+	return tmpl, nil
+}
+
+// lintRequest checks the merge info of req against the tags it will be
+// rendered with. Templated requests are only checked if fetch allows
+// fetching their template.
+func (l *mergeTagLint) lintRequest(ctx context.Context, req any, fetch bool) error {
+	switch r := req.(type) {
+	case SendHTMLEmailReq:
+		c := MergeContent{Subject: r.Subject, HtmlBody: r.HtmlBody, TextBody: r.TextBody, ClientReference: r.ClientReference}
+		return lintMergeContent(c, r.MergeInfo)
+	case SendBatchHTMLEmailReq:
+		c := MergeContent{Subject: r.Subject, HtmlBody: r.HtmlBody, TextBody: r.TextBody, ClientReference: r.ClientReference}
+		for _, to := range r.To {
+			if err := lintMergeContent(c, to.MergeInfo); err != nil {
+				return withRecipient(err, to.EmailAddress)
+			}
+		}
+	case SendTemplatedEmailReq:
+		if !fetch {
+			return nil
+		}
+		tmpl, err := l.template(ctx, r.TemplateKey)
+		if err != nil {
+			return err
+		}
+		return LintMergeInfo(tmpl, r.MergeInfo)
+	case SendBatchTemplatedEmailReq:
+		if !fetch {
+			return nil
+		}
+		tmpl, err := l.template(ctx, r.TemplateKey)
+		if err != nil {
+			return err
+		}
+		for _, to := range r.To {
+			if err := LintMergeInfo(tmpl, to.MergeInfo); err != nil {
+				return withRecipient(err, to.EmailAddress)
+			}
+		}
+	}
+	return nil
+}
+
+func withRecipient(err error, to EmailAddress) error {
+	if mte, ok := err.(*MergeTagError); ok {
+		mte.Recipient = to.Address
+	}
+	return err
+}
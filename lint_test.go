@@ -0,0 +1,88 @@
+package zeptomail_test
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/blancsoft/go-zeptomail"
+)
+
+const templateWelcome = `{"data":{"template_key":"tpl-welcome","subject":"Welcome {{first_name}}",` +
+	`"htmlbody":"<p>{{first_name}}, your plan is {{plan.name}}</p>{{^trial}}<p>Thanks!</p>{{/trial}}",` +
+	`"textbody":"","sample_merge_info":{"first_name":"first_name","plan":{"name":"name"}}},"message":"OK"}`
+
+func TestLintMergeInfo(t *testing.T) {
+	var tmpl zeptomail.GetEmailTemplateRes
+	tmpl.Data.TemplateKey = "tpl"
+	tmpl.Data.Subject = "Hi {{first_name}}"
+	tmpl.Data.HtmlBody = "{{plan.name}} {{coupon}}"
+
+	assert.NoError(t, zeptomail.LintMergeInfo(&tmpl, map[string]any{
+		"first_name": "Ada", "plan": map[string]any{"name": "Pro"}, "coupon": "X",
+	}))
+
+	err := zeptomail.LintMergeInfo(&tmpl, map[string]any{"frist_name": "Ada", "plan": map[string]any{}})
+	var mte *zeptomail.MergeTagError
+	require.ErrorAs(t, err, &mte)
+	assert.Equal(t, "tpl", mte.TemplateKey)
+	assert.Equal(t, []string{"coupon", "first_name", "plan"}, mte.Missing)
+	assert.Equal(t, map[string]string{"first_name": "frist_name"}, mte.Misspelled)
+	assert.Contains(t, err.Error(), `"frist_name" given instead of "first_name"`)
+}
+
+func TestEmailUseMergeTagLint(t *testing.T) {
+	var fetches, sends atomic.Int32
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/templates/tpl-welcome") {
+			fetches.Add(1)
+			_, _ = w.Write([]byte(templateWelcome))
+			return
+		}
+		sends.Add(1)
+		acceptHandler(w, r)
+	})
+	email := (*zeptomail.Email)(client)
+	email.UseMergeTagLint((*zeptomail.Template)(client), time.Hour)
+
+	req := zeptomail.SendTemplatedEmailReq{
+		BaseSendEmail: zeptomail.BaseSendEmail{
+			From:      sender,
+			To:        []zeptomail.SendEmailTo{{EmailAddress: receiver}},
+			MergeInfo: map[string]any{"firstname": "Ada"},
+		},
+		TemplateKey: "tpl-welcome",
+	}
+	_, err := email.SendTemplatedEmail(t.Context(), req)
+	var mte *zeptomail.MergeTagError
+	require.ErrorAs(t, err, &mte)
+	assert.Equal(t, []string{"first_name", "plan"}, mte.Missing)
+	assert.Equal(t, "firstname", mte.Misspelled["first_name"])
+	assert.Zero(t, sends.Load(), "the API is not called")
+
+	req.MergeInfo = map[string]any{"first_name": "Ada", "plan": map[string]any{"name": "Pro"}}
+	_, err = email.SendTemplatedEmail(t.Context(), req)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), sends.Load())
+	assert.Equal(t, int32(1), fetches.Load(), "the template is cached")
+
+	t.Run("batch html", func(t *testing.T) {
+		_, err := email.SendBatchHTMLEmail(t.Context(), zeptomail.SendBatchHTMLEmailReq{
+			From:     sender,
+			Subject:  "Hi {{name}}",
+			HtmlBody: "<p>Hi</p>",
+			To: []zeptomail.SendBatchEmailTo{
+				{EmailAddress: receiver, MergeInfo: map[string]any{"name": "Ada"}},
+				{EmailAddress: other, MergeInfo: map[string]any{"nmae": "Bob"}},
+			},
+		})
+		require.ErrorAs(t, err, &mte)
+		assert.Equal(t, other.Address, mte.Recipient)
+		assert.Equal(t, int32(1), sends.Load())
+	})
+}
@@ -0,0 +1,324 @@
+package zeptomail
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Merge tags are rendered the way ZeptoMail does it server side:
+//
+//	{{name}}                   the value of name
+//	{{user.name}}              a value nested in an object
+//	{{#items}}...{{/items}}    the enclosed text once per item of a list, or
+//	                           once if the value is truthy; the keys of each
+//	                           item are in scope, {{.}} is the item itself
+//	{{^items}}...{{/items}}    the enclosed text if the value is falsy
+
+type (
+	// MergeContent is the text merge tags are rendered in.
+	MergeContent struct {
+		Subject         string
+		HtmlBody        string
+		TextBody        string
+		ClientReference string
+	}
+
+	// RenderedEmail is MergeContent with its merge tags replaced.
+	RenderedEmail struct {
+		MergeContent
+
+		// Tags with no value in the merge info. They are left in the
+		// rendered text as they were.
+		Unresolved []string
+
+		// Keys of the merge info no tag refers to.
+		Unused []string
+	}
+)
+
+// Render replaces the merge tags of c with the values of mergeInfo.
+func (c MergeContent) Render(mergeInfo map[string]any) (*RenderedEmail, error) {
+	r := &mergeRenderer{used: make(map[string]bool), unresolved: make(map[string]bool)}
+
+	fields := []*string{&c.Subject, &c.HtmlBody, &c.TextBody, &c.ClientReference}
+	for _, f := range fields {
+		nodes, err := parseMergeTags(*f)
+		if err != nil {
+			return nil, err
+		}
+		var out strings.Builder
+		r.render(&out, nodes, []any{mergeInfo})
+		*f = out.String()
+	}
+
+	rv := &RenderedEmail{MergeContent: c}
+	for name := range r.unresolved {
+		rv.Unresolved = append(rv.Unresolved, name)
+	}
+	for key := range mergeInfo {
+		if !r.used[key] {
+			rv.Unused = append(rv.Unused, key)
+		}
+	}
+	sort.Strings(rv.Unresolved)
+	sort.Strings(rv.Unused)
+	return rv, nil
+}
+
+// PreviewHTMLEmail renders the merge tags of req locally.
+func PreviewHTMLEmail(req SendHTMLEmailReq) (*RenderedEmail, error) {
+	c := MergeContent{
+		Subject:         req.Subject,
+		HtmlBody:        req.HtmlBody,
+		TextBody:        req.TextBody,
+		ClientReference: req.ClientReference,
+	}
+	return c.Render(req.MergeInfo)
+}
+
+// PreviewBatchHTMLEmail renders the email each recipient of req gets.
+func PreviewBatchHTMLEmail(req SendBatchHTMLEmailReq) ([]*RenderedEmail, error) {
+	c := MergeContent{
+		Subject:         req.Subject,
+		HtmlBody:        req.HtmlBody,
+		TextBody:        req.TextBody,
+		ClientReference: req.ClientReference,
+	}
+	rv := make([]*RenderedEmail, 0, len(req.To))
+	for _, to := range req.To {
+		rendered, err := c.Render(to.MergeInfo)
+		if err != nil {
+			return nil, err
+		}
+		rv = append(rv, rendered)
+	}
+	return rv, nil
+}
+
+// PreviewTemplatedEmail renders tmpl, as returned by
+// Template.GetEmailTemplate, with the merge info of req.
+func PreviewTemplatedEmail(tmpl *GetEmailTemplateRes, req SendTemplatedEmailReq) (*RenderedEmail, error) {
+	c := MergeContent{
+		Subject:         tmpl.Data.Subject,
+		HtmlBody:        tmpl.Data.HtmlBody,
+		TextBody:        tmpl.Data.TextBody,
+		ClientReference: req.ClientReference,
+	}
+	return c.Render(req.MergeInfo)
+}
+
+// MergeTags returns the names of the merge tags in text, in order of first
+// appearance. Tags nested in a section are named relative to it.
+func MergeTags(text string) ([]string, error) {
+	nodes, err := parseMergeTags(text)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		names []string
+		seen  = make(map[string]bool)
+		walk  func([]mergeNode)
+	)
+	walk = func(nodes []mergeNode) {
+		for _, n := range nodes {
+			if n.kind != mergeText && n.name != "." && !seen[n.name] {
+				seen[n.name] = true
+				names = append(names, n.name)
+			}
+			walk(n.children)
+		}
+	}
+	walk(nodes)
+	return names, nil
+}
+
+type mergeNodeKind int
+
+const (
+	mergeText mergeNodeKind = iota
+	mergeVar
+	mergeSection
+	mergeInverted
+)
+
+type mergeNode struct {
+	kind     mergeNodeKind
+	text     string // the text, or the tag as written for the other kinds
+	name     string
+	children []mergeNode
+}
+
+// parseMergeTags parses text into a tree of merge tag nodes.
+func parseMergeTags(text string) ([]mergeNode, error) {
+	type frame struct {
+		node  mergeNode
+		nodes []mergeNode
+	}
+	stack := []frame{{}}
+	top := func() *frame { return &stack[len(stack)-1] }
+
+	for text != "" {
+		open := strings.Index(text, "{{")
+		if open < 0 {
+			top().nodes = append(top().nodes, mergeNode{kind: mergeText, text: text})
+			break
+		}
+		if open > 0 {
+			top().nodes = append(top().nodes, mergeNode{kind: mergeText, text: text[:open]})
+		}
+		end := strings.Index(text[open:], "}}")
+		if end < 0 {
+			// not a tag; keep the braces as text
+			top().nodes = append(top().nodes, mergeNode{kind: mergeText, text: text[open:]})
+			break
+		}
+
+		raw := text[open : open+end+2]
+		inner := strings.TrimSpace(raw[2 : len(raw)-2])
+		text = text[open+end+2:]
+
+		switch {
+		case inner == "":
+			top().nodes = append(top().nodes, mergeNode{kind: mergeText, text: raw})
+		case inner[0] == '#' || inner[0] == '^':
+			kind := mergeSection
+			if inner[0] == '^' {
+				kind = mergeInverted
+			}
+			name := strings.TrimSpace(inner[1:])
+			stack = append(stack, frame{node: mergeNode{kind: kind, text: raw, name: name}})
+		case inner[0] == '/':
+			name := strings.TrimSpace(inner[1:])
+			if len(stack) == 1 || top().node.name != name {
+				return nil, fmt.Errorf("unexpected closing merge tag %s", raw)
+			}
+			f := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			f.node.children = f.nodes
+			top().nodes = append(top().nodes, f.node)
+		default:
+			top().nodes = append(top().nodes, mergeNode{kind: mergeVar, text: raw, name: inner})
+		}
+	}
+
+	if len(stack) > 1 {
+		return nil, fmt.Errorf("unclosed merge tag %s", top().node.text)
+	}
+	return stack[0].nodes, nil
+}
+
+type mergeRenderer struct {
+	used       map[string]bool
+	unresolved map[string]bool
+}
+
+func (r *mergeRenderer) render(out *strings.Builder, nodes []mergeNode, scope []any) {
+	for _, n := range nodes {
+		switch n.kind {
+		case mergeText:
+			out.WriteString(n.text)
+		case mergeVar:
+			v, ok := r.lookup(n.name, scope)
+			if !ok {
+				r.unresolved[n.name] = true
+				out.WriteString(n.text)
+				continue
+			}
+			if v != nil {
+				out.WriteString(fmt.Sprint(v))
+			}
+		case mergeSection:
+			v, ok := r.lookup(n.name, scope)
+			if !ok {
+				r.unresolved[n.name] = true
+				continue
+			}
+			if items, isList := listItems(v); isList {
+				for _, item := range items {
+					r.render(out, n.children, append(scope, item))
+				}
+			} else if truthy(v) {
+				r.render(out, n.children, append(scope, v))
+			}
+		case mergeInverted:
+			v, _ := r.lookup(n.name, scope)
+			if !truthy(v) {
+				r.render(out, n.children, scope)
+			}
+		}
+	}
+}
+
+// lookup resolves a dotted name against the innermost scope defining its
+// first segment.
+func (r *mergeRenderer) lookup(name string, scope []any) (any, bool) {
+	if name == "." {
+		return scope[len(scope)-1], true
+	}
+
+	parts := strings.Split(name, ".")
+	for i := len(scope) - 1; i >= 0; i-- {
+		v, ok := field(scope[i], parts[0])
+		if !ok {
+			continue
+		}
+		if i == 0 {
+			r.used[parts[0]] = true
+		}
+		for _, p := range parts[1:] {
+			if v, ok = field(v, p); !ok {
+				return nil, false
+			}
+		}
+		return v, true
+	}
+	return nil, false
+}
+
+// field returns the value of key in v, if v is a map with string keys.
+func field(v any, key string) (any, bool) {
+	if m, ok := v.(map[string]any); ok {
+		val, found := m[key]
+		return val, found
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Map || rv.Type().Key().Kind() != reflect.String {
+		return nil, false
+	}
+	val := rv.MapIndex(reflect.ValueOf(key).Convert(rv.Type().Key()))
+	if !val.IsValid() {
+		return nil, false
+	}
+	return val.Interface(), true
+}
+
+// listItems returns the items of v if it is a slice or an array.
+func listItems(v any) ([]any, bool) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, false
+	}
+	items := make([]any, rv.Len())
+	for i := range items {
+		items[i] = rv.Index(i).Interface()
+	}
+	return items, true
+}
+
+func truthy(v any) bool {
+	if v == nil {
+		return false
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map, reflect.String:
+		return rv.Len() > 0
+	case reflect.Pointer, reflect.Interface:
+		return !rv.IsNil()
+	}
+	return !rv.IsZero()
+}
@@ -0,0 +1,88 @@
+package zeptomail_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/blancsoft/go-zeptomail"
+)
+
+func TestPreviewHTMLEmail(t *testing.T) {
+	req := zeptomail.SendHTMLEmailReq{
+		BaseSendEmail: zeptomail.BaseSendEmail{
+			MergeInfo: map[string]any{
+				"name":  "Ada",
+				"order": map[string]any{"id": 42, "items": []any{"pen", "ink"}},
+				"vip":   false,
+				"extra": "unused",
+			},
+		},
+		BaseEmailOption: zeptomail.BaseEmailOption{ClientReference: "order-{{order.id}}"},
+		Subject:         "Your order {{ order.id }}",
+		HtmlBody: `<p>Hi {{name}}</p><ul>{{#order.items}}<li>{{.}}</li>{{/order.items}}</ul>` +
+			`{{#vip}}<b>VIP</b>{{/vip}}{{^vip}}<i>regular</i>{{/vip}}<p>{{coupon}}</p>`,
+		TextBody: "Hi {{name}}",
+	}
+
+	got, err := zeptomail.PreviewHTMLEmail(req)
+	require.NoError(t, err)
+	assert.Equal(t, "Your order 42", got.Subject)
+	assert.Equal(t, "order-42", got.ClientReference)
+	assert.Equal(t, "Hi Ada", got.TextBody)
+	assert.Equal(t, `<p>Hi Ada</p><ul><li>pen</li><li>ink</li></ul><i>regular</i><p>{{coupon}}</p>`, got.HtmlBody)
+	assert.Equal(t, []string{"coupon"}, got.Unresolved)
+	assert.Equal(t, []string{"extra"}, got.Unused)
+
+	t.Run("sections of objects", func(t *testing.T) {
+		c := zeptomail.MergeContent{HtmlBody: "{{#rows}}{{sku}}x{{qty}} by {{name}};{{/rows}}"}
+		got, err := c.Render(map[string]any{
+			"name": "Ada",
+			"rows": []map[string]any{{"sku": "A", "qty": 1}, {"sku": "B", "qty": 2}},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "Ax1 by Ada;Bx2 by Ada;", got.HtmlBody)
+		assert.Empty(t, got.Unresolved)
+		assert.Empty(t, got.Unused)
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		_, err := zeptomail.MergeContent{HtmlBody: "{{#a}}x"}.Render(nil)
+		assert.ErrorContains(t, err, "unclosed")
+		_, err = zeptomail.MergeContent{HtmlBody: "{{#a}}x{{/b}}"}.Render(nil)
+		assert.ErrorContains(t, err, "unexpected")
+	})
+}
+
+func TestPreviewBatchAndTemplate(t *testing.T) {
+	batch := zeptomail.SendBatchHTMLEmailReq{
+		From:     sender,
+		Subject:  "Hello {{name}}",
+		HtmlBody: "<p>{{name}}</p>",
+		To: []zeptomail.SendBatchEmailTo{
+			{EmailAddress: receiver, MergeInfo: map[string]any{"name": "Ada"}},
+			{EmailAddress: other},
+		},
+	}
+	previews, err := zeptomail.PreviewBatchHTMLEmail(batch)
+	require.NoError(t, err)
+	require.Len(t, previews, 2)
+	assert.Equal(t, "Hello Ada", previews[0].Subject)
+	assert.Equal(t, "Hello {{name}}", previews[1].Subject)
+	assert.Equal(t, []string{"name"}, previews[1].Unresolved)
+
+	var tmpl zeptomail.GetEmailTemplateRes
+	tmpl.Data.Subject = "Welcome {{name}}"
+	tmpl.Data.HtmlBody = "<p>{{name}}</p>"
+	got, err := zeptomail.PreviewTemplatedEmail(&tmpl, zeptomail.SendTemplatedEmailReq{
+		BaseSendEmail: zeptomail.BaseSendEmail{MergeInfo: map[string]any{"name": "Ada"}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "Welcome Ada", got.Subject)
+	assert.Equal(t, "<p>Ada</p>", got.HtmlBody)
+
+	names, err := zeptomail.MergeTags("{{a}} {{#list}}{{b.c}}{{.}}{{/list}} {{a}}")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "list", "b.c"}, names)
+}
@@ -0,0 +1,439 @@
+package zeptomail
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"slices"
+	"strings"
+	"time"
+)
+
+// MIMEMessage is an email as its recipients get it, merge tags rendered,
+// ready to be written in the RFC 5322 format, e.g. to archive what was
+// asked of ZeptoMail. See HTMLEmailToMIME and the other converters.
+//
+// The body is a multipart/alternative part if the email has both an HTML
+// and a text body, in a multipart/related part along with the inline
+// images, in a multipart/mixed part along with the attachments. Inline
+// images and attachments held in File Cache only are written as
+// message/external-body parts referring to their file_cache_key.
+type MIMEMessage struct {
+	From    EmailAddress
+	To      []EmailAddress
+	CC      []EmailAddress
+	BCC     []EmailAddress
+	ReplyTo []EmailAddress
+
+	Subject  string
+	HtmlBody string
+	TextBody string
+
+	Headers      Headers
+	Attachments  []EmailAttachment
+	InlineImages []InlineImage
+
+	// Made up when the message is written if not set
+	Date      time.Time
+	MessageID string
+}
+
+// HTMLEmailToMIME returns the email req sends.
+func HTMLEmailToMIME(req SendHTMLEmailReq) (*MIMEMessage, error) {
+	msgs, err := mimeMessages(newOutgoingMessage(&req), nil)
+	if err != nil {
+		return nil, err
+	}
+	return msgs[0], nil
+}
+
+// BatchHTMLEmailToMIME returns the emails req sends, one per recipient.
+func BatchHTMLEmailToMIME(req SendBatchHTMLEmailReq) ([]*MIMEMessage, error) {
+	return mimeMessages(newOutgoingMessage(&req), nil)
+}
+
+// TemplatedEmailToMIME returns the email req sends, rendering tmpl, as
+// returned by Template.GetEmailTemplate, locally.
+func TemplatedEmailToMIME(tmpl *GetEmailTemplateRes, req SendTemplatedEmailReq) (*MIMEMessage, error) {
+	msg := newOutgoingMessage(&req)
+	msgs, err := mimeMessages(msg, templateContent(tmpl, msg))
+	if err != nil {
+		return nil, err
+	}
+	return msgs[0], nil
+}
+
+// BatchTemplatedEmailToMIME returns the emails req sends, one per
+// recipient, rendering tmpl, as returned by Template.GetEmailTemplate,
+// locally.
+func BatchTemplatedEmailToMIME(tmpl *GetEmailTemplateRes, req SendBatchTemplatedEmailReq) ([]*MIMEMessage, error) {
+	msg := newOutgoingMessage(&req)
+	return mimeMessages(msg, templateContent(tmpl, msg))
+}
+
+// templateContent returns the content of tmpl, adding its attachments to
+// msg.
+func templateContent(tmpl *GetEmailTemplateRes, msg *OutgoingMessage) *MergeContent {
+	for _, a := range tmpl.Data.Attachments {
+		msg.Attachments = append(msg.Attachments, EmailAttachment{
+			Name:         a.FileName,
+			MimeType:     a.ContentType,
+			FileCacheKey: a.FileCacheKey,
+		})
+	}
+	return &MergeContent{
+		Subject:  tmpl.Data.Subject,
+		HtmlBody: tmpl.Data.HtmlBody,
+		TextBody: tmpl.Data.TextBody,
+	}
+}
+
+// mimeMessages renders msg into the emails ZeptoMail sends for it: one for
+// single emails, one per To recipient for batch emails. content is the
+// content of the template of templated emails, and is ignored for others.
+func mimeMessages(msg *OutgoingMessage, content *MergeContent) ([]*MIMEMessage, error) {
+	c := MergeContent{Subject: msg.Subject, HtmlBody: msg.HtmlBody, TextBody: msg.TextBody}
+	if msg.Kind == TemplatedMessage || msg.Kind == BatchTemplatedMessage {
+		if content == nil {
+			return nil, fmt.Errorf("no content for template %q", msg.TemplateKey)
+		}
+		c = *content
+	}
+
+	newMessage := func(to []EmailAddress, mergeInfo map[string]any) (*MIMEMessage, error) {
+		rendered, err := c.Render(mergeInfo)
+		if err != nil {
+			return nil, err
+		}
+		return &MIMEMessage{
+			From:         msg.From,
+			To:           to,
+			CC:           msg.CC,
+			BCC:          msg.BCC,
+			ReplyTo:      msg.ReplyTo,
+			Subject:      rendered.Subject,
+			HtmlBody:     rendered.HtmlBody,
+			TextBody:     rendered.TextBody,
+			Headers:      msg.MimeHeaders,
+			Attachments:  msg.Attachments,
+			InlineImages: msg.InlineImages,
+		}, nil
+	}
+
+	if msg.Kind == HTMLMessage || msg.Kind == TemplatedMessage {
+		to := make([]EmailAddress, len(msg.To))
+		for i, r := range msg.To {
+			to[i] = r.EmailAddress
+		}
+		m, err := newMessage(to, msg.MergeInfo)
+		if err != nil {
+			return nil, err
+		}
+		return []*MIMEMessage{m}, nil
+	}
+
+	rv := make([]*MIMEMessage, 0, len(msg.To))
+	for _, r := range msg.To {
+		m, err := newMessage([]EmailAddress{r.EmailAddress}, r.MergeInfo)
+		if err != nil {
+			return nil, err
+		}
+		rv = append(rv, m)
+	}
+	return rv, nil
+}
+
+// WriteTo writes m to w in the RFC 5322 format. Bcc recipients are
+// written too, as m is the copy of the sender. Attachments backed by a
+// Reader are read, so m can only be written once.
+func (m *MIMEMessage) WriteTo(w io.Writer) (int64, error) {
+	date := m.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+	messageID := m.MessageID
+	if messageID == "" {
+		_, domain, _ := strings.Cut(m.From.Address, "@")
+		if domain == "" {
+			domain = "localhost"
+		}
+		messageID = "<" + randomHex(16) + "@" + domain + ">"
+	}
+
+	body, err := m.body()
+	if err != nil {
+		return 0, err
+	}
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	writeHeader := func(name, value string) {
+		_, _ = fmt.Fprintf(bw, "%s\r\n", foldHeader(name, value))
+	}
+	writeHeader("Date", date.Format(time.RFC1123Z))
+	writeHeader("Message-ID", messageID)
+	writeHeader("From", formatAddresses([]EmailAddress{m.From}))
+	for _, h := range []struct {
+		name  string
+		addrs []EmailAddress
+	}{{"Reply-To", m.ReplyTo}, {"To", m.To}, {"Cc", m.CC}, {"Bcc", m.BCC}} {
+		if len(h.addrs) > 0 {
+			writeHeader(h.name, formatAddresses(h.addrs))
+		}
+	}
+	writeHeader("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	writeHeader("MIME-Version", "1.0")
+
+	names := make([]string, 0, len(m.Headers))
+	for name := range m.Headers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		if err = ValidateHeader(name, m.Headers[name]); err != nil {
+			return 0, err
+		}
+		writeHeader(name, mime.QEncoding.Encode("utf-8", m.Headers.Get(name)))
+	}
+
+	for _, name := range sortedKeys(body.header) {
+		writeHeader(name, body.header.Get(name))
+	}
+	_, _ = bw.WriteString("\r\n")
+	if err = body.writeBody(bw); err != nil {
+		return cw.n, err
+	}
+	err = bw.Flush()
+	return cw.n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// body returns the MIME tree of m, see MIMEMessage.
+func (m *MIMEMessage) body() (*mimePart, error) {
+	var bodies []*mimePart
+	if m.TextBody != "" || m.HtmlBody == "" {
+		bodies = append(bodies, textPart("text/plain", m.TextBody))
+	}
+	if m.HtmlBody != "" {
+		bodies = append(bodies, textPart("text/html", m.HtmlBody))
+	}
+	root := bodies[0]
+	if len(bodies) > 1 {
+		root = multipartOf("alternative", bodies...)
+	}
+
+	var inline, attached []*mimePart
+	for _, img := range m.InlineImages {
+		p, err := binaryPart(img.MimeType, img.Cid, img.Content, img.FileCacheKey, nil)
+		if err != nil {
+			return nil, fmt.Errorf("inline image %q: %w", img.Cid, err)
+		}
+		setInline(p, img.Cid)
+		inline = append(inline, p)
+	}
+	for _, a := range m.Attachments {
+		p, err := binaryPart(a.MimeType, a.Name, a.Content, a.FileCacheKey, a.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("attachment %q: %w", a.Name, err)
+		}
+		if a.Cid != "" {
+			setInline(p, a.Cid)
+			inline = append(inline, p)
+			continue
+		}
+		if a.Name != "" {
+			p.header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Name}))
+		} else {
+			p.header.Set("Content-Disposition", "attachment")
+		}
+		attached = append(attached, p)
+	}
+
+	if len(inline) > 0 {
+		root = multipartOf("related", append([]*mimePart{root}, inline...)...)
+	}
+	if len(attached) > 0 {
+		root = multipartOf("mixed", append([]*mimePart{root}, attached...)...)
+	}
+	return root, nil
+}
+
+// mimePart is a part of a MIME tree: a multipart part if it has parts, a
+// leaf written by write otherwise.
+type mimePart struct {
+	header textproto.MIMEHeader
+	parts  []*mimePart
+	write  func(w io.Writer) error
+}
+
+func multipartOf(subtype string, parts ...*mimePart) *mimePart {
+	boundary := "zm-" + randomHex(12)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", mime.FormatMediaType("multipart/"+subtype, map[string]string{"boundary": boundary}))
+	return &mimePart{header: header, parts: parts}
+}
+
+func textPart(mediaType, text string) *mimePart {
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", mediaType+"; charset=utf-8")
+	header.Set("Content-Transfer-Encoding", "quoted-printable")
+	return &mimePart{header: header, write: func(w io.Writer) error {
+		qw := quotedprintable.NewWriter(w)
+		if _, err := io.WriteString(qw, text); err != nil {
+			return err
+		}
+		return qw.Close()
+	}}
+}
+
+// binaryPart returns a base64 encoded part with the given content, base64
+// encoded, or the content of r. Parts held in File Cache only are written
+// as message/external-body parts referring to their key.
+func binaryPart(mediaType, name, content, fileCacheKey string, r io.Reader) (*mimePart, error) {
+	if mediaType == "" {
+		mediaType = "application/octet-stream"
+	}
+	header := textproto.MIMEHeader{}
+	params := map[string]string{}
+	if name != "" {
+		params["name"] = name
+	}
+
+	if content == "" && r == nil {
+		if fileCacheKey == "" {
+			return nil, fmt.Errorf("no content or file cache key")
+		}
+		params["access-type"] = "x-zeptomail-file-cache"
+		params["file-cache-key"] = fileCacheKey
+		header.Set("Content-Type", mime.FormatMediaType("message/external-body", params))
+		return &mimePart{header: header, write: func(w io.Writer) error {
+			_, err := fmt.Fprintf(w, "Content-Type: %s\r\n\r\n", mediaType)
+			return err
+		}}, nil
+	}
+
+	raw, err := attachmentBytes(content, r)
+	if err != nil {
+		return nil, err
+	}
+	header.Set("Content-Type", mime.FormatMediaType(mediaType, params))
+	header.Set("Content-Transfer-Encoding", "base64")
+	return &mimePart{header: header, write: func(w io.Writer) error {
+		encoded := base64.StdEncoding.EncodeToString(raw)
+		for len(encoded) > 76 {
+			if _, err := io.WriteString(w, encoded[:76]+"\r\n"); err != nil {
+				return err
+			}
+			encoded = encoded[76:]
+		}
+		_, err := io.WriteString(w, encoded+"\r\n")
+		return err
+	}}, nil
+}
+
+// attachmentBytes decodes content, or reads r if content is empty.
+func attachmentBytes(content string, r io.Reader) ([]byte, error) {
+	if content == "" {
+		return io.ReadAll(r)
+	}
+	raw, err := base64.StdEncoding.DecodeString(content)
+	if err != nil {
+		return nil, fmt.Errorf("decoding content failed: %w", err)
+	}
+	return raw, nil
+}
+
+func setInline(p *mimePart, cid string) {
+	p.header.Set("Content-ID", "<"+strings.Trim(cid, "<>")+">")
+	p.header.Set("Content-Disposition", "inline")
+}
+
+// writeBody writes the body of p, its parts for a multipart part.
+func (p *mimePart) writeBody(w io.Writer) error {
+	if p.parts == nil {
+		return p.write(w)
+	}
+	_, params, err := mime.ParseMediaType(p.header.Get("Content-Type"))
+	if err != nil {
+		return err
+	}
+	mw := multipart.NewWriter(w)
+	if err = mw.SetBoundary(params["boundary"]); err != nil {
+		return err
+	}
+	for _, part := range p.parts {
+		pw, err := mw.CreatePart(part.header)
+		if err != nil {
+			return err
+		}
+		if err = part.writeBody(pw); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+// maxHeaderLine is the length RFC 5322 section 2.1.1 recommends header
+// lines stay within, well below the limit of 998 characters.
+const maxHeaderLine = 78
+
+// foldHeader returns the header field name: value, folded at spaces into
+// lines of at most maxHeaderLine characters, the first one possibly right
+// after the colon. Words are never split, but encoded-words are at most 75
+// characters long.
+func foldHeader(name, value string) string {
+	var b strings.Builder
+	b.WriteString(name)
+	b.WriteByte(':')
+	lineLen := b.Len()
+	for _, word := range strings.Split(value, " ") {
+		if word != "" && lineLen+1+len(word) > maxHeaderLine {
+			b.WriteString("\r\n")
+			lineLen = 0
+		}
+		b.WriteByte(' ')
+		b.WriteString(word)
+		lineLen += 1 + len(word)
+	}
+	return b.String()
+}
+
+func formatAddresses(addrs []EmailAddress) string {
+	s := make([]string, len(addrs))
+	for i, a := range addrs {
+		s[i] = a.MailAddress().String()
+	}
+	return strings.Join(s, ", ")
+}
+
+func sortedKeys(h textproto.MIMEHeader) []string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
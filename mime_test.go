@@ -0,0 +1,164 @@
+package zeptomail_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/blancsoft/go-zeptomail"
+)
+
+// mimeParts returns the media types of the parts of a MIME entity, depth
+// first, multipart parts included.
+func mimeParts(t *testing.T, contentType string, body io.Reader) []string {
+	t.Helper()
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	require.NoError(t, err)
+	rv := []string{mediaType}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		return rv
+	}
+	mr := multipart.NewReader(body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			return rv
+		}
+		require.NoError(t, err)
+		rv = append(rv, mimeParts(t, p.Header.Get("Content-Type"), p)...)
+	}
+}
+
+func TestHTMLEmailToMIME(t *testing.T) {
+	m, err := zeptomail.HTMLEmailToMIME(zeptomail.SendHTMLEmailReq{
+		BaseSendEmail: zeptomail.BaseSendEmail{
+			From:      sender,
+			To:        []zeptomail.SendEmailTo{{EmailAddress: receiver}},
+			MergeInfo: map[string]any{"name": "Zoë"},
+		},
+		BaseEmailOption: zeptomail.BaseEmailOption{
+			BCC:         []zeptomail.SendEmailTo{{EmailAddress: other}},
+			MimeHeaders: testHeaders,
+			Attachments: []zeptomail.EmailAttachment{{
+				Name:     "note.txt",
+				MimeType: "text/plain",
+				Content:  base64.StdEncoding.EncodeToString([]byte("attached")),
+			}},
+			InlineImages: []zeptomail.InlineImage{{Cid: "logo", MimeType: "image/png", FileCacheKey: "key-1"}},
+		},
+		Subject:  "Héllo {{name}}",
+		HtmlBody: `<p>Hello {{name}}</p><img src="cid:logo">`,
+		TextBody: "Hello {{name}}",
+	})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	n, err := m.WriteTo(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, int64(buf.Len()), n)
+
+	msg, err := mail.ReadMessage(&buf)
+	require.NoError(t, err)
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	require.NoError(t, err)
+	assert.Equal(t, "Héllo Zoë", subject)
+	assert.Equal(t, "1.0", msg.Header.Get("MIME-Version"))
+	assert.Equal(t, "go-zeptomail", msg.Header.Get("X-Tester"))
+	assert.NotEmpty(t, msg.Header.Get("Message-ID"))
+	bcc, err := msg.Header.AddressList("Bcc")
+	require.NoError(t, err)
+	assert.Equal(t, other.Address, bcc[0].Address)
+
+	assert.Equal(t, []string{
+		"multipart/mixed",
+		"multipart/related",
+		"multipart/alternative", "text/plain", "text/html",
+		"message/external-body",
+		"text/plain",
+	}, mimeParts(t, msg.Header.Get("Content-Type"), msg.Body))
+}
+
+func TestMIMEFoldsLongHeaders(t *testing.T) {
+	long := strings.TrimSpace(strings.Repeat("Héllo wörld, ", 100))
+	m, err := zeptomail.HTMLEmailToMIME(zeptomail.SendHTMLEmailReq{
+		BaseSendEmail: zeptomail.BaseSendEmail{
+			From: sender,
+			To:   []zeptomail.SendEmailTo{{EmailAddress: receiver}},
+		},
+		BaseEmailOption: zeptomail.BaseEmailOption{MimeHeaders: zeptomail.Headers{"X-Campaign": long}},
+		Subject:         long,
+		HtmlBody:        "<p>Hello</p>",
+	})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	_, err = m.WriteTo(&buf)
+	require.NoError(t, err)
+	header, _, _ := strings.Cut(buf.String(), "\r\n\r\n")
+	for _, line := range strings.Split(header, "\r\n") {
+		assert.LessOrEqual(t, len(line), 78, line)
+	}
+
+	msg, err := mail.ReadMessage(&buf)
+	require.NoError(t, err)
+	dec := new(mime.WordDecoder)
+	subject, err := dec.DecodeHeader(msg.Header.Get("Subject"))
+	require.NoError(t, err)
+	assert.Equal(t, long, subject)
+	campaign, err := dec.DecodeHeader(msg.Header.Get("X-Campaign"))
+	require.NoError(t, err)
+	assert.Equal(t, long, campaign)
+}
+
+func TestTemplatedEmailToMIME(t *testing.T) {
+	var tmpl zeptomail.GetEmailTemplateRes
+	tmpl.Data.Subject = "Welcome {{name}}"
+	tmpl.Data.HtmlBody = "<p>Welcome {{name}}</p>"
+	tmpl.Data.Attachments = append(tmpl.Data.Attachments, struct {
+		FileCacheKey string `json:"file_cache_key"`
+		ContentType  string `json:"content_type"`
+		FileName     string `json:"file_name"`
+	}{FileCacheKey: "key-2", ContentType: "application/pdf", FileName: "terms.pdf"})
+
+	m, err := zeptomail.TemplatedEmailToMIME(&tmpl, zeptomail.SendTemplatedEmailReq{
+		BaseSendEmail: zeptomail.BaseSendEmail{
+			From:      sender,
+			To:        []zeptomail.SendEmailTo{{EmailAddress: receiver}},
+			MergeInfo: map[string]any{"name": "Ada"},
+		},
+		TemplateKey: "welcome",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "Welcome Ada", m.Subject)
+	assert.Equal(t, "<p>Welcome Ada</p>", m.HtmlBody)
+	require.Len(t, m.Attachments, 1, "template attachments are kept")
+
+	var buf bytes.Buffer
+	_, err = m.WriteTo(&buf)
+	require.NoError(t, err)
+	msg, err := mail.ReadMessage(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"multipart/mixed", "text/html", "message/external-body"},
+		mimeParts(t, msg.Header.Get("Content-Type"), msg.Body))
+
+	batch, err := zeptomail.BatchTemplatedEmailToMIME(&tmpl, zeptomail.SendBatchTemplatedEmailReq{
+		TemplateKey: "welcome",
+		From:        sender,
+		To: []zeptomail.SendBatchEmailTo{
+			{EmailAddress: receiver, MergeInfo: map[string]any{"name": "Ada"}},
+			{EmailAddress: other, MergeInfo: map[string]any{"name": "Bob"}},
+		},
+	})
+	require.NoError(t, err)
+	require.Len(t, batch, 2)
+	assert.Equal(t, "Welcome Bob", batch[1].Subject)
+	assert.Equal(t, []zeptomail.EmailAddress{other}, batch[1].To)
+}
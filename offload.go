@@ -0,0 +1,255 @@
+package zeptomail
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OffloadCache remembers the File Cache key of content already uploaded,
+// by the hex encoded SHA-256 of the content. A *FileCacheRegistry is an
+// OffloadCache that also tracks the age of its keys.
+type OffloadCache interface {
+	// Get returns the key of the content with the given sum, if known.
+	Get(ctx context.Context, sum string) (key string, ok bool, err error)
+	Put(ctx context.Context, sum, key string) error
+}
+
+type attachmentOffload struct {
+	threshold int
+	registry  *FileCacheRegistry
+}
+
+// UseFileCacheOffload makes every attachment larger than threshold bytes
+// be uploaded to File Cache before sending, and sent as a reference to its
+// file_cache_key rather than inline. The keys are kept in cache, so a file
+// attached to many emails is only uploaded once. cache is typically a
+// *FileCacheRegistry; without one, keys are kept in memory and uploaded
+// again after FileCacheRetention. Whatever the cache, when ZeptoMail
+// rejects a key the file is uploaded again and the email resent once.
+//
+// Attachments backed by a Reader are read into memory to be hashed.
+func (e *Email) UseFileCacheOffload(threshold int, cache ...OffloadCache) {
+	o := &attachmentOffload{threshold: threshold}
+	var c OffloadCache
+	if len(cache) > 0 {
+		c = cache[0]
+	}
+	switch c := c.(type) {
+	case *FileCacheRegistry:
+		o.registry = c
+	case nil:
+		o.registry = NewFileCacheRegistry((*FileCache)(e), NewMemoryFileCacheStore(), 0)
+	default:
+		o.registry = NewFileCacheRegistry((*FileCache)(e), offloadCacheStore{c}, 0)
+	}
+	e.offload = o
+}
+
+// offloadedAttachment is an attachment of a request sent by reference.
+type offloadedAttachment struct {
+	index   int
+	sum     string
+	content []byte
+}
+
+// sendOffloaded sends req with its large attachments swapped for File
+// Cache references, uploading them again and resending once if ZeptoMail
+// rejects a reference.
+func sendOffloaded[S, R any](e *Email, ctx context.Context, endpoint *url.URL, req S) (*WrappedResponse[R], error) {
+	offloaded, err := offloadAttachments(e, ctx, &req)
+	if err != nil {
+		return nil, err
+	}
+	rv, err := request[S, R]((*Client)(e), ctx, http.MethodPost, endpoint, nil, req)
+	if len(offloaded) == 0 || rv == nil || !fileCacheKeyRejected(rv.RawResponse) {
+		return rv, err
+	}
+
+	// ZeptoMail no longer holds the files; forget their keys and upload again
+	list := any(&req).(attachmentCarrier).attachmentList()
+	for _, o := range offloaded {
+		if err := e.offload.registry.Forget(ctx, o.sum); err != nil {
+			return nil, fmt.Errorf("forgetting file cache key failed: %w", err)
+		}
+		a := (*list)[o.index]
+		entry, err := e.offload.registry.UploadBytes(ctx, a.Name, o.content)
+		if err != nil {
+			return nil, err
+		}
+		(*list)[o.index].FileCacheKey = entry.FileCacheKey
+	}
+	return request[S, R]((*Client)(e), ctx, http.MethodPost, endpoint, nil, req)
+}
+
+// offloadAttachments swaps the large attachments of req for File Cache
+// references, returning them. The attachments are copied first, so the
+// caller's request is left as is.
+func offloadAttachments(e *Email, ctx context.Context, req any) ([]offloadedAttachment, error) {
+	ac, ok := req.(attachmentCarrier)
+	if !ok {
+		return nil, nil
+	}
+
+	list := ac.attachmentList()
+	cloned := false
+	var offloaded []offloadedAttachment
+	for i, a := range *list {
+		if a.FileCacheKey != "" {
+			continue
+		}
+
+		content, err := attachmentContent(a)
+		if err != nil {
+			return nil, err
+		}
+		if len(content) <= e.offload.threshold {
+			if a.Reader == nil {
+				continue
+			}
+			// the reader has been consumed; keep its content inline
+			a.Reader, a.Content = nil, base64.StdEncoding.EncodeToString(content)
+		} else {
+			entry, err := e.offload.registry.UploadBytes(ctx, a.Name, content)
+			if err != nil {
+				return nil, err
+			}
+			a = EmailAttachment{Name: a.Name, FileCacheKey: entry.FileCacheKey, Cid: a.Cid}
+			offloaded = append(offloaded, offloadedAttachment{index: i, sum: entry.SHA256, content: content})
+		}
+
+		if !cloned {
+			*list = slices.Clone(*list)
+			cloned = true
+		}
+		(*list)[i] = a
+	}
+	return offloaded, nil
+}
+
+// fileCacheKeyRejected reports whether res is an API error about a
+// file_cache_key. The body of res is left readable.
+func fileCacheKeyRejected(res *http.Response) bool {
+	if res == nil || res.StatusCode < 400 || res.StatusCode >= 500 {
+		return false
+	}
+	body, err := io.ReadAll(res.Body)
+	res.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return false
+	}
+
+	var rv struct {
+		Error *ErrorResponse `json:"error"`
+	}
+	if json.Unmarshal(body, &rv) != nil || rv.Error == nil {
+		return false
+	}
+	mentions := func(s string) bool {
+		s = strings.ToLower(s)
+		return strings.Contains(s, "file_cache_key") || strings.Contains(s, "file cache")
+	}
+	if mentions(rv.Error.Target) || mentions(rv.Error.Message) {
+		return true
+	}
+	for _, d := range rv.Error.Details {
+		if mentions(d.Target) || mentions(d.Message) {
+			return true
+		}
+	}
+	return false
+}
+
+// attachmentContent returns the decoded content of a.
+func attachmentContent(a EmailAttachment) ([]byte, error) {
+	if a.Reader != nil {
+		content, err := io.ReadAll(&sizeLimitReader{r: a.Reader, n: MaxAttachmentSize})
+		if err != nil {
+			return nil, fmt.Errorf("reading attachment %s failed: %w", a.Name, err)
+		}
+		return content, nil
+	}
+	content, err := base64.StdEncoding.DecodeString(a.Content)
+	if err != nil {
+		return nil, fmt.Errorf("decoding attachment %s failed: %w", a.Name, err)
+	}
+	return content, nil
+}
+
+// offloadCacheStore is a FileCacheStore backed by an OffloadCache. Its
+// entries only hold the key and never go stale: keys ZeptoMail rejects are
+// forgotten and uploaded again.
+type offloadCacheStore struct {
+	cache OffloadCache
+}
+
+func (s offloadCacheStore) Get(ctx context.Context, sum string) (*FileCacheEntry, error) {
+	key, ok, err := s.cache.Get(ctx, sum)
+	if err != nil || !ok || key == "" {
+		return nil, err
+	}
+	return &FileCacheEntry{SHA256: sum, FileCacheKey: key, UploadedAt: time.Now()}, nil
+}
+
+func (s offloadCacheStore) Put(ctx context.Context, entry FileCacheEntry) error {
+	return s.cache.Put(ctx, entry.SHA256, entry.FileCacheKey)
+}
+
+// Delete forgets the key of sum, through the Delete method of the cache
+// if it has one, or by storing an empty key.
+func (s offloadCacheStore) Delete(ctx context.Context, sum string) error {
+	if d, ok := s.cache.(interface {
+		Delete(ctx context.Context, sum string) error
+	}); ok {
+		return d.Delete(ctx, sum)
+	}
+	return s.cache.Put(ctx, sum, "")
+}
+
+func (s offloadCacheStore) List(context.Context) ([]FileCacheEntry, error) {
+	return nil, nil
+}
+
+// MemoryOffloadCache is an OffloadCache held in memory.
+type MemoryOffloadCache struct {
+	mu   sync.RWMutex
+	keys map[string]string
+}
+
+// NewMemoryOffloadCache returns an empty MemoryOffloadCache.
+func NewMemoryOffloadCache() *MemoryOffloadCache {
+	return &MemoryOffloadCache{keys: make(map[string]string)}
+}
+
+// Get implements OffloadCache.
+func (c *MemoryOffloadCache) Get(_ context.Context, sum string) (string, bool, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	key, ok := c.keys[sum]
+	return key, ok, nil
+}
+
+// Put implements OffloadCache.
+func (c *MemoryOffloadCache) Put(_ context.Context, sum, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.keys[sum] = key
+	return nil
+}
+
+// Delete forgets the key of the content with the given sum.
+func (c *MemoryOffloadCache) Delete(_ context.Context, sum string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.keys, sum)
+	return nil
+}
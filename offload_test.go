@@ -0,0 +1,124 @@
+package zeptomail_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/blancsoft/go-zeptomail"
+)
+
+// fileCacheServer fakes the File Cache and send endpoints.
+type fileCacheServer struct {
+	mu      sync.Mutex
+	uploads int
+	sent    []zeptomail.SendHTMLEmailReq
+
+	// rejected is a file_cache_key answered with an error
+	rejected string
+}
+
+func (s *fileCacheServer) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if strings.HasSuffix(r.URL.Path, "/files") {
+		s.uploads++
+		w.WriteHeader(http.StatusCreated)
+		_, _ = fmt.Fprintf(w, `{"file_cache_key":"key-%d","data":[],"message":"OK","object":"file"}`, s.uploads)
+		return
+	}
+
+	var req zeptomail.SendHTMLEmailReq
+	_ = json.NewDecoder(r.Body).Decode(&req)
+	s.sent = append(s.sent, req)
+	for _, a := range req.Attachments {
+		if s.rejected != "" && a.FileCacheKey == s.rejected {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":{"code":"TM_3201","details":[{"code":"SERR_157","message":"Invalid file_cache_key","target":"file_cache_key"}],"message":"Invalid data"}}`))
+			return
+		}
+	}
+	acceptHandler(w, r)
+}
+
+func TestFileCacheOffload(t *testing.T) {
+	server := &fileCacheServer{}
+	email := (*zeptomail.Email)(newTestClient(t, server.handle))
+	email.UseFileCacheOffload(1024)
+
+	terms, err := zeptomail.AttachmentFromBytes("terms.pdf", bytes.Repeat([]byte("T&C "), 1024))
+	require.NoError(t, err)
+	small, err := zeptomail.AttachmentFromBytes("note.txt", []byte("small"))
+	require.NoError(t, err)
+	streamed, err := zeptomail.StreamAttachment("copy.pdf", bytes.NewReader(bytes.Repeat([]byte("T&C "), 1024)))
+	require.NoError(t, err)
+
+	req := streamedReq(terms, small)
+	for range 2 {
+		_, err = email.SendHTMLEmail(t.Context(), req)
+		require.NoError(t, err)
+	}
+	_, err = email.SendHTMLEmail(t.Context(), streamedReq(streamed))
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, server.uploads, "identical content is uploaded once")
+	require.Len(t, server.sent, 3)
+	for _, sent := range server.sent {
+		assert.Equal(t, "key-1", sent.Attachments[0].FileCacheKey)
+		assert.Empty(t, sent.Attachments[0].Content)
+	}
+	assert.Equal(t, small.Content, server.sent[0].Attachments[1].Content)
+	assert.Empty(t, server.sent[0].Attachments[1].FileCacheKey)
+	assert.Equal(t, terms, req.Attachments[0], "caller's request is left untouched")
+}
+
+func TestFileCacheOffloadCache(t *testing.T) {
+	server := &fileCacheServer{}
+	email := (*zeptomail.Email)(newTestClient(t, server.handle))
+	cache := zeptomail.NewMemoryOffloadCache()
+	email.UseFileCacheOffload(1024, cache)
+
+	terms, err := zeptomail.AttachmentFromBytes("terms.pdf", bytes.Repeat([]byte("T&C "), 1024))
+	require.NoError(t, err)
+	for range 2 {
+		_, err = email.SendHTMLEmail(t.Context(), streamedReq(terms))
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 1, server.uploads, "identical content is uploaded once")
+
+	sum := sha256.Sum256(bytes.Repeat([]byte("T&C "), 1024))
+	key, ok, err := cache.Get(t.Context(), hex.EncodeToString(sum[:]))
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "key-1", key)
+}
+
+func TestFileCacheOffloadRejectedKey(t *testing.T) {
+	server := &fileCacheServer{rejected: "key-1"}
+	email := (*zeptomail.Email)(newTestClient(t, server.handle))
+	email.UseFileCacheOffload(1024)
+
+	terms, err := zeptomail.AttachmentFromBytes("terms.pdf", bytes.Repeat([]byte("T&C "), 1024))
+	require.NoError(t, err)
+	rv, err := email.SendHTMLEmail(t.Context(), streamedReq(terms))
+	require.NoError(t, err)
+	assert.Nil(t, rv.Data.Error)
+	assert.Equal(t, 2, server.uploads, "the rejected file is uploaded again")
+	require.Len(t, server.sent, 2)
+	assert.Equal(t, "key-2", server.sent[1].Attachments[0].FileCacheKey)
+
+	_, err = email.SendHTMLEmail(t.Context(), streamedReq(terms))
+	require.NoError(t, err)
+	assert.Equal(t, 2, server.uploads, "the new key is reused")
+	assert.Equal(t, "key-2", server.sent[2].Attachments[0].FileCacheKey)
+}
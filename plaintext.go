@@ -0,0 +1,253 @@
+package zeptomail
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// HTMLToText converts an HTML body to a readable plain text alternative:
+//
+//   - headings are prefixed with #, one per level,
+//   - list items are bulleted, or numbered in ordered lists,
+//   - links are numbered like footnotes, listed with their URL at the end,
+//   - table rows are flattened to one line, cells separated by spaces,
+//   - images are replaced with their alt text,
+//   - scripts, styles and the head are dropped.
+//
+// Merge tags are kept as they are.
+func HTMLToText(body string) (string, error) {
+	t := &textWriter{}
+	z := html.NewTokenizer(strings.NewReader(body))
+
+	var (
+		skip  int // depth in elements whose content is dropped
+		lists []int
+		hrefs []string // of the open links, "" for links without a footnote
+		links []string
+		pre   int
+	)
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if err := z.Err(); err != io.EOF {
+				return "", err
+			}
+			break
+		}
+
+		tok := z.Token()
+		switch tt {
+		case html.TextToken:
+			if skip > 0 {
+				continue
+			}
+			if pre > 0 {
+				t.raw(tok.Data)
+			} else {
+				t.text(tok.Data)
+			}
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			selfClosing := tt == html.SelfClosingTagToken
+			switch tok.DataAtom {
+			case atom.Head, atom.Script, atom.Style, atom.Title, atom.Template:
+				if !selfClosing {
+					skip++
+				}
+			case atom.Br:
+				t.newline(1)
+			case atom.Hr:
+				t.newline(2)
+				t.raw("----------")
+				t.newline(2)
+			case atom.P, atom.Blockquote, atom.Table:
+				t.newline(2)
+			case atom.Div, atom.Section, atom.Article, atom.Header, atom.Footer, atom.Tr:
+				t.newline(1)
+			case atom.Pre:
+				t.newline(2)
+				pre++
+			case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+				t.newline(2)
+				t.raw(strings.Repeat("#", int(tok.Data[1]-'0')) + " ")
+			case atom.Ul:
+				t.newline(1)
+				lists = append(lists, 0)
+			case atom.Ol:
+				t.newline(1)
+				lists = append(lists, 1)
+			case atom.Li:
+				t.newline(1)
+				t.raw(strings.Repeat("  ", max(len(lists)-1, 0)))
+				if n := len(lists); n > 0 && lists[n-1] > 0 {
+					t.raw(fmt.Sprintf("%d. ", lists[n-1]))
+					lists[n-1]++
+				} else {
+					t.raw("* ")
+				}
+			case atom.Td, atom.Th:
+				t.space()
+			case atom.Img:
+				if alt, _ := attr(&tok, "alt"); skip == 0 {
+					t.text(alt)
+				}
+			case atom.A:
+				if selfClosing {
+					continue
+				}
+				href, _ := attr(&tok, "href")
+				if !isFootnoteLink(href) {
+					href = ""
+				}
+				hrefs = append(hrefs, href)
+				t.mark()
+			}
+
+		case html.EndTagToken:
+			switch tok.DataAtom {
+			case atom.Head, atom.Script, atom.Style, atom.Title, atom.Template:
+				skip = max(skip-1, 0)
+			case atom.P, atom.Blockquote, atom.Table,
+				atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+				t.newline(2)
+			case atom.Pre:
+				pre = max(pre-1, 0)
+				t.newline(2)
+			case atom.Div, atom.Section, atom.Article, atom.Header, atom.Footer, atom.Tr, atom.Li:
+				t.newline(1)
+			case atom.Ul, atom.Ol:
+				if len(lists) > 0 {
+					lists = lists[:len(lists)-1]
+				}
+				t.newline(1)
+			case atom.A:
+				if len(hrefs) == 0 {
+					continue
+				}
+				href := hrefs[len(hrefs)-1]
+				hrefs = hrefs[:len(hrefs)-1]
+				if href == "" || t.sinceMark() == href {
+					continue
+				}
+				links = append(links, href)
+				t.raw(fmt.Sprintf(" [%d]", len(links)))
+			}
+		}
+	}
+
+	out := strings.TrimSpace(t.String())
+	if len(links) > 0 {
+		var b strings.Builder
+		b.WriteString(out)
+		b.WriteString("\n\n")
+		for i, href := range links {
+			fmt.Fprintf(&b, "[%d] %s\n", i+1, href)
+		}
+		out = strings.TrimSpace(b.String())
+	}
+	return out, nil
+}
+
+// isFootnoteLink reports whether href is worth listing as a footnote.
+func isFootnoteLink(href string) bool {
+	href = strings.TrimSpace(href)
+	if href == "" || strings.HasPrefix(href, "#") {
+		return false
+	}
+	scheme, _, _ := strings.Cut(strings.ToLower(href), ":")
+	return scheme != "mailto" && scheme != "tel" && scheme != "javascript"
+}
+
+// textWriter builds plain text, collapsing whitespace and blank lines.
+type textWriter struct {
+	b        strings.Builder
+	newlines int // pending, written before the next text
+	spaced   bool
+	marked   int
+}
+
+func (t *textWriter) String() string { return t.b.String() }
+
+// newline ends the current line, leaving n-1 blank lines before the next text.
+func (t *textWriter) newline(n int) {
+	if t.b.Len() > 0 {
+		t.newlines = max(t.newlines, n)
+	}
+}
+
+// space separates the next text from the current one.
+func (t *textWriter) space() {
+	t.spaced = true
+}
+
+// text writes s with its whitespace collapsed.
+func (t *textWriter) text(s string) {
+	words := strings.FieldsFunc(s, unicode.IsSpace)
+	if len(words) == 0 {
+		if s != "" {
+			t.spaced = true
+		}
+		return
+	}
+	if unicode.IsSpace(rune(s[0])) {
+		t.spaced = true
+	}
+	t.raw(strings.Join(words, " "))
+	t.spaced = unicode.IsSpace(rune(s[len(s)-1]))
+}
+
+// raw writes s as is.
+func (t *textWriter) raw(s string) {
+	if s == "" {
+		return
+	}
+	if t.newlines > 0 {
+		t.b.WriteString(strings.Repeat("\n", t.newlines))
+		t.newlines = 0
+	} else if t.spaced && !t.afterSpace() {
+		t.b.WriteByte(' ')
+	}
+	t.spaced = false
+	t.b.WriteString(s)
+}
+
+// afterSpace reports whether the text so far is empty or ends in whitespace.
+func (t *textWriter) afterSpace() bool {
+	s := t.b.String()
+	return s == "" || unicode.IsSpace(rune(s[len(s)-1]))
+}
+
+// mark remembers the current position, see sinceMark.
+func (t *textWriter) mark() {
+	t.marked = t.b.Len()
+}
+
+// sinceMark returns the text written since the last mark.
+func (t *textWriter) sinceMark() string {
+	return strings.TrimSpace(t.b.String()[t.marked:])
+}
+
+// TextAlternative is a Transformer giving HTML emails sent without a
+// TextBody one, converted from their HtmlBody with HTMLToText.
+func TextAlternative(_ context.Context, msg *OutgoingMessage) error {
+	if msg.TextBody != "" || msg.HtmlBody == "" {
+		return nil
+	}
+	text, err := HTMLToText(msg.HtmlBody)
+	if err != nil {
+		return fmt.Errorf("converting HtmlBody to text failed: %w", err)
+	}
+	msg.TextBody = text
+	return nil
+}
+
+// UseTextAlternative adds TextAlternative to the transformers of e.
+func (e *Email) UseTextAlternative() {
+	e.UseTransformers(TextAlternative)
+}
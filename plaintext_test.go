@@ -0,0 +1,113 @@
+package zeptomail_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/blancsoft/go-zeptomail"
+)
+
+func TestHTMLToText(t *testing.T) {
+	body := `<html><head><title>Ignored</title><style>p { color: red }</style></head><body>
+<h1>Welcome,  {{name}}!</h1>
+<p>Thanks for
+   signing up. Read the <a href="https://example.com/guide">getting started guide</a>
+   or <a href="mailto:help@example.com">email us</a>.</p>
+<ul><li>Fast</li><li>Safe &amp; sound
+  <ol><li>one</li><li>two</li></ol></li></ul>
+<table><tr><th>Plan</th><th>Price</th></tr><tr><td>Pro</td><td>$10</td></tr></table>
+<p><img src="cid:logo" alt="Example Inc."><br>Visit <a href="https://example.com">https://example.com</a></p>
+<script>alert(1)</script>
+</body></html>`
+
+	got, err := zeptomail.HTMLToText(body)
+	require.NoError(t, err)
+	assert.Equal(t, `# Welcome, {{name}}!
+
+Thanks for signing up. Read the getting started guide [1] or email us.
+
+* Fast
+* Safe & sound
+  1. one
+  2. two
+
+Plan Price
+Pro $10
+
+Example Inc.
+Visit https://example.com
+
+[1] https://example.com/guide`, got)
+}
+
+func TestEmailUseTextAlternative(t *testing.T) {
+	var sent map[string]any
+	email := (*zeptomail.Email)(newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		require.NoError(t, json.Unmarshal(b, &sent))
+		acceptHandler(w, r)
+	}))
+	email.UseTextAlternative()
+
+	req := zeptomail.SendHTMLEmailReq{
+		BaseSendEmail: zeptomail.BaseSendEmail{
+			From:      sender,
+			To:        []zeptomail.SendEmailTo{{EmailAddress: receiver}},
+			MergeInfo: map[string]any{"name": "Ada"},
+		},
+		Subject:  emailSubject,
+		HtmlBody: "<p>Hello <b>there</b></p>",
+	}
+	_, err := email.SendHTMLEmail(t.Context(), req)
+	require.NoError(t, err)
+	assert.Equal(t, "Hello there", sent["textbody"])
+	assert.Empty(t, req.TextBody, "the caller's request is left as is")
+
+	req.TextBody = "custom"
+	_, err = email.SendHTMLEmail(t.Context(), req)
+	require.NoError(t, err)
+	assert.Equal(t, "custom", sent["textbody"], "a given text body is kept")
+}
+
+func TestSendTextOnlyEmail(t *testing.T) {
+	var sent map[string]any
+	email := (*zeptomail.Email)(newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		sent = nil
+		b, _ := io.ReadAll(r.Body)
+		require.NoError(t, json.Unmarshal(b, &sent))
+		acceptHandler(w, r)
+	}))
+
+	req := zeptomail.SendHTMLEmailReq{
+		BaseSendEmail: zeptomail.BaseSendEmail{
+			From:      sender,
+			To:        []zeptomail.SendEmailTo{{EmailAddress: receiver}},
+			MergeInfo: map[string]any{"name": "Ada"},
+		},
+		Subject:  emailSubject,
+		TextBody: "Hello there",
+	}
+	_, err := email.SendHTMLEmail(t.Context(), req)
+	require.NoError(t, err)
+	assert.Equal(t, "Hello there", sent["textbody"])
+	assert.NotContains(t, sent, "htmlbody")
+
+	req.TextBody = ""
+	_, err = email.SendHTMLEmail(t.Context(), req)
+	assert.ErrorContains(t, err, "HtmlBody", "a body is required")
+
+	_, err = email.SendBatchHTMLEmail(t.Context(), zeptomail.SendBatchHTMLEmailReq{
+		From:     sender,
+		To:       []zeptomail.SendBatchEmailTo{{EmailAddress: receiver}},
+		Subject:  emailSubject,
+		TextBody: "Hello there",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "Hello there", sent["textbody"])
+	assert.NotContains(t, sent, "htmlbody")
+}
@@ -0,0 +1,337 @@
+package zeptomail
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+type (
+	// FileCacheEntry describes a file uploaded to File Cache.
+	FileCacheEntry struct {
+		Name         string    `json:"name"`
+		SHA256       string    `json:"sha256"`
+		Size         int64     `json:"size"`
+		MimeType     string    `json:"mime_type"`
+		FileCacheKey string    `json:"file_cache_key"`
+		UploadedAt   time.Time `json:"uploaded_at"`
+	}
+
+	// FileCacheStore persists the entries of a FileCacheRegistry, keyed by
+	// the hex encoded SHA-256 of the file content.
+	FileCacheStore interface {
+		// Get returns the entry of the content with the given sum, or nil.
+		Get(ctx context.Context, sum string) (*FileCacheEntry, error)
+		Put(ctx context.Context, entry FileCacheEntry) error
+		Delete(ctx context.Context, sum string) error
+		List(ctx context.Context) ([]FileCacheEntry, error)
+	}
+)
+
+// FileCacheRetention is how long a file uploaded to File Cache is relied
+// upon by default, see NewFileCacheRegistry.
+const FileCacheRetention = 30 * 24 * time.Hour
+
+// FileCacheRegistry keeps track of the files uploaded through a FileCache,
+// so that a file is uploaded once and its key reused afterwards.
+type FileCacheRegistry struct {
+	fileCache *FileCache
+	store     FileCacheStore
+	maxAge    time.Duration
+
+	// uploading makes concurrent uploads of the same content wait for a
+	// single one
+	uploading keyedMutex
+}
+
+// NewFileCacheRegistry returns a FileCacheRegistry uploading through
+// fileCache and recording entries in store. Entries older than maxAge are
+// stale: the file is uploaded again the next time it is needed. A maxAge of
+// zero means FileCacheRetention, a negative one that entries never go
+// stale.
+func NewFileCacheRegistry(fileCache *FileCache, store FileCacheStore, maxAge time.Duration) *FileCacheRegistry {
+	if maxAge == 0 {
+		maxAge = FileCacheRetention
+	}
+	return &FileCacheRegistry{fileCache: fileCache, store: store, maxAge: maxAge}
+}
+
+func (r *FileCacheRegistry) fresh(entry *FileCacheEntry) bool {
+	return entry != nil && (r.maxAge <= 0 || time.Since(entry.UploadedAt) < r.maxAge)
+}
+
+// Lookup returns the entry of the content with the given hex encoded
+// SHA-256, or nil if it has not been uploaded or its entry is stale.
+func (r *FileCacheRegistry) Lookup(ctx context.Context, sum string) (*FileCacheEntry, error) {
+	entry, err := r.store.Get(ctx, sum)
+	if err != nil || !r.fresh(entry) {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// Forget deletes the entry of the content with the given hex encoded
+// SHA-256, e.g. once ZeptoMail rejected its key, so that it is uploaded
+// again the next time it is needed.
+func (r *FileCacheRegistry) Forget(ctx context.Context, sum string) error {
+	return r.store.Delete(ctx, sum)
+}
+
+// Get implements OffloadCache.
+func (r *FileCacheRegistry) Get(ctx context.Context, sum string) (string, bool, error) {
+	entry, err := r.Lookup(ctx, sum)
+	if err != nil || entry == nil {
+		return "", false, err
+	}
+	return entry.FileCacheKey, true, nil
+}
+
+// Put implements OffloadCache, recording key as uploaded now.
+func (r *FileCacheRegistry) Put(ctx context.Context, sum, key string) error {
+	return r.store.Put(ctx, FileCacheEntry{SHA256: sum, FileCacheKey: key, UploadedAt: time.Now()})
+}
+
+// Find reads content to its end and returns the entry it was uploaded
+// with, or nil if it has not been uploaded or its entry is stale.
+func (r *FileCacheRegistry) Find(ctx context.Context, content io.Reader) (*FileCacheEntry, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, content); err != nil {
+		return nil, err
+	}
+	return r.Lookup(ctx, hex.EncodeToString(h.Sum(nil)))
+}
+
+// Upload returns the entry of the content of rs, uploading it as name
+// unless a fresh entry already exists. rs is read twice: once to hash it,
+// then to stream it to File Cache.
+func (r *FileCacheRegistry) Upload(ctx context.Context, name string, rs io.ReadSeeker) (*FileCacheEntry, error) {
+	start, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(rs, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("reading %s failed: %w", name, err)
+	}
+	head = head[:n]
+
+	h := sha256.New()
+	h.Write(head)
+	rest, err := io.Copy(h, rs)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s failed: %w", name, err)
+	}
+	size, sum := int64(n)+rest, hex.EncodeToString(h.Sum(nil))
+
+	unlock := r.uploading.Lock(sum)
+	defer unlock()
+
+	if entry, err := r.Lookup(ctx, sum); err != nil || entry != nil {
+		return entry, err
+	}
+
+	if _, err = rs.Seek(start, io.SeekStart); err != nil {
+		return nil, err
+	}
+	mimeType := DetectContentType(name, head)
+	rv, err := r.fileCache.Upload(ctx, name, io.LimitReader(rs, size),
+		WithContentType(mimeType), WithContentLength(size))
+	if err != nil {
+		return nil, fmt.Errorf("uploading %s failed: %w", name, err)
+	}
+	if rv.Data.Error != nil {
+		return nil, fmt.Errorf("uploading %s failed: %s", name, rv.Data.Error.Message)
+	}
+	if rv.Data.FileCacheKey == "" {
+		return nil, fmt.Errorf("uploading %s failed: no file_cache_key in response", name)
+	}
+
+	entry := FileCacheEntry{
+		Name:         name,
+		SHA256:       sum,
+		Size:         size,
+		MimeType:     mimeType,
+		FileCacheKey: rv.Data.FileCacheKey,
+		UploadedAt:   time.Now(),
+	}
+	if err = r.store.Put(ctx, entry); err != nil {
+		return nil, fmt.Errorf("recording %s failed: %w", name, err)
+	}
+	return &entry, nil
+}
+
+// UploadBytes is Upload for content held in memory.
+func (r *FileCacheRegistry) UploadBytes(ctx context.Context, name string, content []byte) (*FileCacheEntry, error) {
+	return r.Upload(ctx, name, bytes.NewReader(content))
+}
+
+// UploadFile is Upload for the file at path, named after its base name.
+func (r *FileCacheRegistry) UploadFile(ctx context.Context, path string) (*FileCacheEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	return r.Upload(ctx, filepath.Base(path), f)
+}
+
+// Export writes every entry, stale ones included, to w as a JSON array
+// ordered by upload time.
+func (r *FileCacheRegistry) Export(ctx context.Context, w io.Writer) error {
+	entries, err := r.store.List(ctx)
+	if err != nil {
+		return err
+	}
+	sortFileCacheEntries(entries)
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(entries)
+}
+
+func sortFileCacheEntries(entries []FileCacheEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].UploadedAt.Equal(entries[j].UploadedAt) {
+			return entries[i].UploadedAt.Before(entries[j].UploadedAt)
+		}
+		return entries[i].SHA256 < entries[j].SHA256
+	})
+}
+
+// MemoryFileCacheStore is a FileCacheStore held in memory.
+type MemoryFileCacheStore struct {
+	mu      sync.RWMutex
+	entries map[string]FileCacheEntry
+}
+
+// NewMemoryFileCacheStore returns an empty MemoryFileCacheStore.
+func NewMemoryFileCacheStore() *MemoryFileCacheStore {
+	return &MemoryFileCacheStore{entries: make(map[string]FileCacheEntry)}
+}
+
+// Get implements FileCacheStore.
+func (s *MemoryFileCacheStore) Get(_ context.Context, sum string) (*FileCacheEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entry, ok := s.entries[sum]
+	if !ok {
+		return nil, nil
+	}
+	return &entry, nil
+}
+
+// Put implements FileCacheStore.
+func (s *MemoryFileCacheStore) Put(_ context.Context, entry FileCacheEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[entry.SHA256] = entry
+	return nil
+}
+
+// Delete implements FileCacheStore.
+func (s *MemoryFileCacheStore) Delete(_ context.Context, sum string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, sum)
+	return nil
+}
+
+// List implements FileCacheStore.
+func (s *MemoryFileCacheStore) List(context.Context) ([]FileCacheEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entries := make([]FileCacheEntry, 0, len(s.entries))
+	for _, entry := range s.entries {
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// JSONFileCacheStore is a FileCacheStore kept in memory and saved to a
+// JSON file, in the format written by FileCacheRegistry.Export, on every
+// change.
+type JSONFileCacheStore struct {
+	path string
+	mem  *MemoryFileCacheStore
+	mu   sync.Mutex
+}
+
+// NewJSONFileCacheStore returns a JSONFileCacheStore saved to path, loading
+// the entries it already holds.
+func NewJSONFileCacheStore(path string) (*JSONFileCacheStore, error) {
+	s := &JSONFileCacheStore{path: path, mem: NewMemoryFileCacheStore()}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []FileCacheEntry
+	if err = json.Unmarshal(b, &entries); err != nil {
+		return nil, fmt.Errorf("decoding %s failed: %w", path, err)
+	}
+	for _, entry := range entries {
+		s.mem.entries[entry.SHA256] = entry
+	}
+	return s, nil
+}
+
+// Get implements FileCacheStore.
+func (s *JSONFileCacheStore) Get(ctx context.Context, sum string) (*FileCacheEntry, error) {
+	return s.mem.Get(ctx, sum)
+}
+
+// List implements FileCacheStore.
+func (s *JSONFileCacheStore) List(ctx context.Context) ([]FileCacheEntry, error) {
+	return s.mem.List(ctx)
+}
+
+// Put implements FileCacheStore.
+func (s *JSONFileCacheStore) Put(ctx context.Context, entry FileCacheEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.mem.Put(ctx, entry); err != nil {
+		return err
+	}
+	return s.save(ctx)
+}
+
+// Delete implements FileCacheStore.
+func (s *JSONFileCacheStore) Delete(ctx context.Context, sum string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.mem.Delete(ctx, sum); err != nil {
+		return err
+	}
+	return s.save(ctx)
+}
+
+func (s *JSONFileCacheStore) save(ctx context.Context) error {
+	entries, err := s.mem.List(ctx)
+	if err != nil {
+		return err
+	}
+	sortFileCacheEntries(entries)
+
+	b, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, b)
+}
@@ -0,0 +1,106 @@
+package zeptomail_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/blancsoft/go-zeptomail"
+)
+
+func TestFileCacheRegistry(t *testing.T) {
+	server := &fileCacheServer{}
+	fileCache := (*zeptomail.FileCache)(newTestClient(t, server.handle))
+	storePath := filepath.Join(t.TempDir(), "registry.json")
+	store, err := zeptomail.NewJSONFileCacheStore(storePath)
+	require.NoError(t, err)
+	registry := zeptomail.NewFileCacheRegistry(fileCache, store, time.Hour)
+
+	content := bytes.Repeat([]byte("%PDF-1.7 terms "), 100)
+	sum := sha256.Sum256(content)
+	hexSum := hex.EncodeToString(sum[:])
+
+	found, err := registry.Find(t.Context(), bytes.NewReader(content))
+	require.NoError(t, err)
+	assert.Nil(t, found)
+
+	entry, err := registry.UploadBytes(t.Context(), "terms.pdf", content)
+	require.NoError(t, err)
+	assert.Equal(t, zeptomail.FileCacheEntry{
+		Name:         "terms.pdf",
+		SHA256:       hexSum,
+		Size:         int64(len(content)),
+		MimeType:     "application/pdf",
+		FileCacheKey: "key-1",
+		UploadedAt:   entry.UploadedAt,
+	}, *entry)
+
+	p := filepath.Join(t.TempDir(), "copy.pdf")
+	require.NoError(t, os.WriteFile(p, content, 0o600))
+	again, err := registry.UploadFile(t.Context(), p)
+	require.NoError(t, err)
+	assert.Equal(t, "key-1", again.FileCacheKey)
+	assert.Equal(t, 1, server.uploads, "known content is not uploaded again")
+
+	found, err = registry.Find(t.Context(), bytes.NewReader(content))
+	require.NoError(t, err)
+	require.NotNil(t, found)
+	assert.Equal(t, "key-1", found.FileCacheKey)
+
+	t.Run("persisted", func(t *testing.T) {
+		reopened, err := zeptomail.NewJSONFileCacheStore(storePath)
+		require.NoError(t, err)
+		got, err := reopened.Get(t.Context(), hexSum)
+		require.NoError(t, err)
+		require.NotNil(t, got)
+		assert.Equal(t, "key-1", got.FileCacheKey)
+	})
+
+	t.Run("export", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, registry.Export(t.Context(), &buf))
+		var exported []zeptomail.FileCacheEntry
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &exported))
+		require.Len(t, exported, 1)
+		assert.Equal(t, hexSum, exported[0].SHA256)
+	})
+
+	t.Run("stale entries are uploaded again", func(t *testing.T) {
+		stale := *entry
+		stale.UploadedAt = time.Now().Add(-2 * time.Hour)
+		require.NoError(t, store.Put(t.Context(), stale))
+
+		looked, err := registry.Lookup(t.Context(), hexSum)
+		require.NoError(t, err)
+		assert.Nil(t, looked)
+
+		renewed, err := registry.UploadBytes(t.Context(), "terms.pdf", content)
+		require.NoError(t, err)
+		assert.Equal(t, "key-2", renewed.FileCacheKey)
+		assert.Equal(t, 2, server.uploads)
+	})
+
+	t.Run("default max age is the File Cache retention", func(t *testing.T) {
+		registry := zeptomail.NewFileCacheRegistry(fileCache, store, 0)
+		old := *entry
+		old.UploadedAt = time.Now().Add(-zeptomail.FileCacheRetention + time.Hour)
+		require.NoError(t, store.Put(t.Context(), old))
+		looked, err := registry.Lookup(t.Context(), hexSum)
+		require.NoError(t, err)
+		assert.NotNil(t, looked)
+
+		old.UploadedAt = time.Now().Add(-zeptomail.FileCacheRetention - time.Hour)
+		require.NoError(t, store.Put(t.Context(), old))
+		looked, err = registry.Lookup(t.Context(), hexSum)
+		require.NoError(t, err)
+		assert.Nil(t, looked)
+	})
+}
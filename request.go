@@ -21,6 +21,25 @@ type Client struct {
 	baseURL       *url.URL
 	mailAgent     string
 	authorisation string
+
+	// idempotency dedupes repeated sends, see Email.UseIdempotency
+	idempotency *idempotencyLayer
+	// offload moves large attachments to File Cache, see Email.UseFileCacheOffload
+	offload *attachmentOffload
+	// mergeLint checks merge info before sending, see Email.UseMergeTagLint
+	mergeLint *mergeTagLint
+	// transformers change emails before sending, see Email.UseTransformers
+	transformers []Transformer
+	// suppression drops suppressed recipients after the transformers, see
+	// Email.UseSuppressionList
+	suppression Transformer
+	// sandbox keeps emails away from real recipients, last of all, see
+	// Email.UseSandbox
+	sandbox Transformer
+	// cssInlining inlines style blocks of templates, see Template.UseCSSInlining
+	cssInlining *cssInlining
+	// dryRun answers requests instead of the API, see Email.UseDryRun
+	dryRun *DryRun
 }
 
 func NewClient(mailAgent, authorisation string, defaultClient ...*http.Client) (*Client, error) {
@@ -65,22 +84,33 @@ func request[S any, R any](
 	v := reflect.ValueOf(payload)
 	hasPayload := v.IsValid() && !v.IsZero()
 
-	var buff bytes.Buffer
+	var (
+		buff    bytes.Buffer
+		streams map[string]io.Reader
+	)
 	if hasPayload {
 		if err := validate.Struct(&payload); err != nil {
 			return nil, err
 		}
 
+		// attachments backed by a reader are encoded into the body as it is sent
+		streams = detachStreams(&payload)
 		if err := json.NewEncoder(&buff).Encode(payload); err != nil {
 			return nil, fmt.Errorf("encoding failed: %w", err)
 		}
+	}
 
-		{ // TODO: Remove me
-			fmt.Printf("RAW PAYLOAD: %s\n", buff.String())
-		}
+	var (
+		reqBody io.Reader = &buff
+		stream  *streamReader
+	)
+	if len(streams) > 0 {
+		stream = streamBody(buff.Bytes(), streams)
+		defer func() { _ = stream.Close() }()
+		reqBody = stream
 	}
 
-	req, err := http.NewRequest(method, endpoint.String(), &buff)
+	req, err := http.NewRequest(method, endpoint.String(), reqBody)
 	if err != nil {
 		return nil, fmt.Errorf("new request failed: %w", err)
 	}
@@ -93,17 +123,35 @@ func request[S any, R any](
 	for k, v := range headers {
 		req.Header[k] = v
 	}
+	if c.dryRun != nil {
+		return dryRunDo[R](c.dryRun, req)
+	}
+	rv, err := do[R](c, req)
+	if stream != nil && stream.Err() != nil {
+		// the server answered a truncated body
+		return rv, fmt.Errorf("request failed: %w", stream.Err())
+	}
+	return rv, err
+}
 
-	var rv WrappedResponse[R]
-	rv.RawResponse, err = c.client.Do(req)
+// do sends req and decodes the JSON response body into R.
+func do[R any](c *Client, req *http.Request) (*WrappedResponse[R], error) {
+	res, err := c.client.Do(req)
 	if err != nil {
-		return &rv, fmt.Errorf("request failed: %w", err)
+		return &WrappedResponse[R]{RawResponse: res}, fmt.Errorf("request failed: %w", err)
 	}
+	return decodeResponse[R](res)
+}
+
+// decodeResponse decodes the JSON body of res into R, leaving the body
+// readable again.
+func decodeResponse[R any](res *http.Response) (*WrappedResponse[R], error) {
+	rv := WrappedResponse[R]{RawResponse: res}
 
 	var body bytes.Buffer
 	rv.RawResponse.Body = io.NopCloser(io.TeeReader(rv.RawResponse.Body, &body))
 
-	if err = json.NewDecoder(rv.RawResponse.Body).Decode(&rv.Data); err != nil && !errors.Is(err, io.EOF) {
+	if err := json.NewDecoder(rv.RawResponse.Body).Decode(&rv.Data); err != nil && !errors.Is(err, io.EOF) {
 		return &rv, fmt.Errorf("decoding failed: %w", err)
 	}
 	rv.RawResponse.Body = io.NopCloser(&body)
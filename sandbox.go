@@ -0,0 +1,184 @@
+package zeptomail
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+const (
+	// OriginalRecipientsHeader lists the recipients a sandboxed email was
+	// redirected away from, e.g. "To: a@example.com; Cc: b@example.com".
+	OriginalRecipientsHeader = "X-Original-Recipients"
+
+	// sandboxMergeTag holds the original address of each recipient of a
+	// redirected batch email, for its subject prefix.
+	sandboxMergeTag = "zeptomail_original_to"
+)
+
+// SandboxConfig configures the sandbox mode of an Email, see Sandbox.
+type SandboxConfig struct {
+	// Addresses and domains that may be emailed, e.g. "qa@example.com" or
+	// "example.com". A domain also allows its subdomains.
+	Allow []string `validate:"required_without=CatchAll"`
+
+	// Address the recipients that are not allowed are redirected to. If
+	// empty, they are dropped instead.
+	CatchAll string `validate:"required_without=Allow,omitempty,email"`
+
+	// Tag the subject of redirected HTML emails is prefixed with, along
+	// with the original recipients. By default "SANDBOX". Templated emails
+	// are not tagged, as the API takes their subject from the template:
+	// they only carry the OriginalRecipientsHeader header.
+	SubjectTag string
+}
+
+// Sandbox returns a Transformer keeping emails away from the recipients
+// cfg does not allow, for staging and other environments that must not
+// email real users. It applies to every kind of send alike.
+//
+// Without a CatchAll address, the To, CC and BCC recipients that are not
+// allowed are dropped, and emails left without a To recipient are vetoed.
+//
+// With one, they are redirected to CatchAll: the To recipients that are
+// not allowed are replaced by it, and the CC and BCC ones dropped. The
+// original recipients are listed in the OriginalRecipientsHeader header
+// and, for HTML emails, in a prefix of the subject; templates have their
+// own subject, so templated emails only get the header. Each recipient of
+// a batch email is redirected separately, keeping its merge info, and the
+// subject names that recipient alone.
+func Sandbox(cfg SandboxConfig) (Transformer, error) {
+	if err := validate.Struct(&cfg); err != nil {
+		return nil, err
+	}
+	if cfg.SubjectTag == "" {
+		cfg.SubjectTag = "SANDBOX"
+	}
+	s := &sandbox{cfg: cfg}
+	return s.transform, nil
+}
+
+// UseSandbox runs the Sandbox transformer for cfg on every email sent
+// through e. It runs last, after the transformers of e and the suppression
+// list, whatever the order they were added in, so that no recipient they
+// add escapes it. The subjects of templated emails are not tagged, see
+// SandboxConfig.SubjectTag.
+func (e *Email) UseSandbox(cfg SandboxConfig) error {
+	t, err := Sandbox(cfg)
+	if err != nil {
+		return err
+	}
+	e.sandbox = t
+	return nil
+}
+
+type sandbox struct {
+	cfg SandboxConfig
+}
+
+// allowed reports whether address may be emailed.
+func (s *sandbox) allowed(address string) bool {
+	address = strings.ToLower(strings.TrimSpace(address))
+	if s.cfg.CatchAll != "" && strings.EqualFold(address, s.cfg.CatchAll) {
+		return true
+	}
+	_, domain, _ := strings.Cut(address, "@")
+	for _, a := range s.cfg.Allow {
+		a = strings.ToLower(strings.TrimSpace(a))
+		if strings.Contains(strings.TrimPrefix(a, "@"), "@") {
+			if a == address {
+				return true
+			}
+		} else if matchesDomain(domain, []string{strings.TrimPrefix(a, "@")}) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *sandbox) transform(_ context.Context, msg *OutgoingMessage) error {
+	var redirected [3][]string
+	keep := func(i int) func(EmailAddress) bool {
+		return func(a EmailAddress) bool {
+			if s.allowed(a.Address) {
+				return true
+			}
+			redirected[i] = append(redirected[i], a.Address)
+			return false
+		}
+	}
+	msg.CC = filterAddresses(msg.CC, keep(1))
+	msg.BCC = filterAddresses(msg.BCC, keep(2))
+
+	batch := msg.Kind == BatchHTMLMessage || msg.Kind == BatchTemplatedMessage
+	var (
+		to          []OutgoingRecipient
+		catchAllSet bool
+	)
+	for _, r := range msg.To {
+		if keep(0)(r.EmailAddress) {
+			to = append(to, r)
+			continue
+		}
+		if s.cfg.CatchAll == "" {
+			continue
+		}
+		if batch {
+			r.MergeInfo = cloneMergeInfo(r.MergeInfo)
+			r.MergeInfo[sandboxMergeTag] = r.Address
+			r.Address = s.cfg.CatchAll
+			to = append(to, r)
+		} else if !catchAllSet {
+			to = append(to, OutgoingRecipient{EmailAddress: EmailAddress{Address: s.cfg.CatchAll}})
+			catchAllSet = true
+		}
+	}
+	msg.To = to
+
+	if len(redirected[0])+len(redirected[1])+len(redirected[2]) == 0 {
+		return nil
+	}
+	if len(msg.To) == 0 {
+		return Veto("no recipient is allowed in the sandbox")
+	}
+	if s.cfg.CatchAll == "" {
+		return nil
+	}
+
+	var original []string
+	for i, field := range []string{"To", "Cc", "Bcc"} {
+		if len(redirected[i]) > 0 {
+			original = append(original, field+": "+strings.Join(redirected[i], ", "))
+		}
+	}
+	if msg.MimeHeaders == nil {
+		msg.MimeHeaders = make(Headers, 1)
+	}
+	msg.MimeHeaders.Set(OriginalRecipientsHeader, strings.Join(original, "; "))
+
+	switch {
+	case msg.Kind == BatchHTMLMessage:
+		// Allowed recipients kept their address, which the prefix shows as is.
+		for i := range msg.To {
+			if _, ok := msg.To[i].MergeInfo[sandboxMergeTag]; !ok {
+				msg.To[i].MergeInfo = cloneMergeInfo(msg.To[i].MergeInfo)
+				msg.To[i].MergeInfo[sandboxMergeTag] = msg.To[i].Address
+			}
+		}
+		msg.Subject = fmt.Sprintf("[%s to {{%s}}] %s", s.cfg.SubjectTag, sandboxMergeTag, msg.Subject)
+	case msg.Kind == HTMLMessage && len(redirected[0]) > 0:
+		msg.Subject = fmt.Sprintf("[%s to %s] %s", s.cfg.SubjectTag, strings.Join(redirected[0], ", "), msg.Subject)
+	case msg.Kind == HTMLMessage:
+		msg.Subject = fmt.Sprintf("[%s] %s", s.cfg.SubjectTag, msg.Subject)
+	}
+	return nil
+}
+
+// cloneMergeInfo returns a copy of mergeInfo that can be written to.
+func cloneMergeInfo(mergeInfo map[string]any) map[string]any {
+	rv := make(map[string]any, len(mergeInfo)+1)
+	for k, v := range mergeInfo {
+		rv[k] = v
+	}
+	return rv
+}
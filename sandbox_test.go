@@ -0,0 +1,127 @@
+package zeptomail_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/blancsoft/go-zeptomail"
+)
+
+func TestSandboxAllowList(t *testing.T) {
+	sandbox, err := zeptomail.Sandbox(zeptomail.SandboxConfig{Allow: []string{"example.com", "qa@test.dev"}})
+	require.NoError(t, err)
+
+	msg := &zeptomail.OutgoingMessage{
+		Kind: zeptomail.HTMLMessage,
+		To: []zeptomail.OutgoingRecipient{
+			{EmailAddress: zeptomail.EmailAddress{Address: "ada@mail.example.com"}},
+			{EmailAddress: zeptomail.EmailAddress{Address: "customer@gmail.com"}},
+		},
+		CC:      []zeptomail.EmailAddress{{Address: "QA@test.dev"}, {Address: "boss@test.dev"}},
+		Subject: "Hi",
+	}
+	require.NoError(t, sandbox(t.Context(), msg))
+	require.Len(t, msg.To, 1)
+	assert.Equal(t, "ada@mail.example.com", msg.To[0].Address)
+	assert.Equal(t, []zeptomail.EmailAddress{{Address: "QA@test.dev"}}, msg.CC)
+	assert.Equal(t, "Hi", msg.Subject)
+	assert.False(t, msg.MimeHeaders.Has(zeptomail.OriginalRecipientsHeader))
+
+	msg.To = []zeptomail.OutgoingRecipient{{EmailAddress: zeptomail.EmailAddress{Address: "customer@gmail.com"}}}
+	assert.True(t, zeptomail.IsVetoed(sandbox(t.Context(), msg)))
+
+	_, err = zeptomail.Sandbox(zeptomail.SandboxConfig{})
+	assert.Error(t, err, "either Allow or CatchAll is required")
+}
+
+func TestSandboxCatchAll(t *testing.T) {
+	var sent map[string]any
+	email := (*zeptomail.Email)(newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		sent = nil
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&sent))
+		acceptHandler(w, r)
+	}))
+	require.NoError(t, email.UseSandbox(zeptomail.SandboxConfig{CatchAll: "staging@example.com"}))
+
+	_, err := email.SendHTMLEmail(t.Context(), zeptomail.SendHTMLEmailReq{
+		BaseSendEmail: zeptomail.BaseSendEmail{
+			From:      sender,
+			To:        []zeptomail.SendEmailTo{{EmailAddress: receiver}, {EmailAddress: other}},
+			MergeInfo: map[string]any{"name": "Ada"},
+		},
+		BaseEmailOption: zeptomail.BaseEmailOption{
+			BCC: []zeptomail.SendEmailTo{{EmailAddress: zeptomail.EmailAddress{Address: "audit@corp.test"}}},
+		},
+		Subject:  emailSubject,
+		HtmlBody: emailBody,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []any{map[string]any{"email_address": map[string]any{"address": "staging@example.com"}}}, sent["to"])
+	assert.Nil(t, sent["bcc"])
+	assert.Equal(t, "[SANDBOX to "+receiver.Address+", "+other.Address+"] "+emailSubject, sent["subject"])
+	assert.Equal(t, "To: "+receiver.Address+", "+other.Address+"; Bcc: audit@corp.test",
+		sent["mime_headers"].(map[string]any)[zeptomail.OriginalRecipientsHeader])
+
+	_, err = email.SendBatchHTMLEmail(t.Context(), zeptomail.SendBatchHTMLEmailReq{
+		From: sender,
+		To: []zeptomail.SendBatchEmailTo{
+			{EmailAddress: receiver, MergeInfo: map[string]any{"name": "Ada"}},
+			{EmailAddress: other},
+		},
+		Subject:  emailSubject,
+		HtmlBody: emailBody,
+	})
+	require.NoError(t, err)
+	to := sent["to"].([]any)
+	require.Len(t, to, 2)
+	for i, want := range []string{receiver.Address, other.Address} {
+		r := to[i].(map[string]any)
+		assert.Equal(t, "staging@example.com", r["email_address"].(map[string]any)["address"])
+		assert.Equal(t, want, r["merge_info"].(map[string]any)["zeptomail_original_to"])
+	}
+	assert.Equal(t, "Ada", to[0].(map[string]any)["merge_info"].(map[string]any)["name"])
+	assert.Equal(t, "[SANDBOX to {{zeptomail_original_to}}] "+emailSubject, sent["subject"])
+
+	_, err = email.SendTemplatedEmail(t.Context(), zeptomail.SendTemplatedEmailReq{
+		BaseSendEmail: zeptomail.BaseSendEmail{
+			From:      sender,
+			To:        []zeptomail.SendEmailTo{{EmailAddress: receiver}},
+			MergeInfo: map[string]any{"name": "Ada"},
+		},
+		TemplateKey: "tpl",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "To: "+receiver.Address, sent["mime_headers"].(map[string]any)[zeptomail.OriginalRecipientsHeader])
+	assert.Nil(t, sent["subject"])
+}
+
+func TestSandboxRunsLast(t *testing.T) {
+	var sent map[string]any
+	email := (*zeptomail.Email)(newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		sent = nil
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&sent))
+		acceptHandler(w, r)
+	}))
+	require.NoError(t, email.UseSandbox(zeptomail.SandboxConfig{Allow: []string{"blancsoft.com"}}))
+	email.UseTransformers(func(_ context.Context, msg *zeptomail.OutgoingMessage) error {
+		msg.BCC = append(msg.BCC, zeptomail.EmailAddress{Address: "audit@corp.test"})
+		return nil
+	})
+
+	_, err := email.SendHTMLEmail(t.Context(), zeptomail.SendHTMLEmailReq{
+		BaseSendEmail: zeptomail.BaseSendEmail{
+			From:      sender,
+			To:        []zeptomail.SendEmailTo{{EmailAddress: receiver}},
+			MergeInfo: map[string]any{},
+		},
+		Subject:  emailSubject,
+		HtmlBody: emailBody,
+	})
+	require.NoError(t, err)
+	assert.Nil(t, sent["bcc"], "recipients added by transformers added later are sandboxed")
+}
@@ -0,0 +1,68 @@
+package zeptomail
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+)
+
+// Placeholder returns the bind parameter a SQL driver expects for the
+// n-th (1-based) argument of a statement.
+type Placeholder func(n int) string
+
+// QuestionPlaceholder binds arguments as "?" (MySQL, SQLite). It is the
+// default for the SQL backed stores.
+func QuestionPlaceholder(int) string { return "?" }
+
+// DollarPlaceholder binds arguments as "$1", "$2", ... (PostgreSQL).
+func DollarPlaceholder(n int) string { return fmt.Sprintf("$%d", n) }
+
+var sqlIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+
+// sqlTable holds what every SQL backed store needs to build its statements.
+type sqlTable struct {
+	db          *sql.DB
+	name        string
+	placeholder Placeholder
+}
+
+func newSQLTable(db *sql.DB, name string, placeholder []Placeholder) (sqlTable, error) {
+	if !sqlIdentifier.MatchString(name) {
+		return sqlTable{}, fmt.Errorf("invalid table name %q", name)
+	}
+	t := sqlTable{db: db, name: name, placeholder: QuestionPlaceholder}
+	if len(placeholder) > 0 && placeholder[0] != nil {
+		t.placeholder = placeholder[0]
+	}
+	return t, nil
+}
+
+// query formats a statement, substituting the table name for the first %s
+// and bind parameters for the remaining ones.
+func (t sqlTable) query(format string, args int) string {
+	values := make([]any, 0, args+1)
+	values = append(values, t.name)
+	for i := 1; i <= args; i++ {
+		values = append(values, t.placeholder(i))
+	}
+	return fmt.Sprintf(format, values...)
+}
+
+// replace deletes the rows matched by del and inserts a new one in a single
+// transaction, which is the only upsert every SQL dialect agrees on.
+func (t sqlTable) replace(ctx context.Context, del string, delArgs []any, ins string, insArgs []any) error {
+	tx, err := t.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err = tx.ExecContext(ctx, del, delArgs...); err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, ins, insArgs...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,27 @@
+package zeptomail_test
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+)
+
+// newTestDB returns an in-memory SQLite database holding the given
+// tables, for the SQL backed stores.
+func newTestDB(t *testing.T, schema ...string) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", ":memory:")
+	require.NoError(t, err)
+	// every connection would open a database of its own
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = db.Close() })
+
+	for _, stmt := range schema {
+		_, err = db.Exec(stmt)
+		require.NoError(t, err)
+	}
+	return db
+}
@@ -0,0 +1,151 @@
+package zeptomail
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+)
+
+// StreamAttachment returns an attachment whose content is read from r, and
+// base64 encoded into the request, while the email is being sent. The MIME
+// type is taken from the extension of name, or sniffed from the first bytes
+// of r. r must not be read by anything else until the email is sent.
+func StreamAttachment(name string, r io.Reader) (EmailAttachment, error) {
+	if err := checkAttachmentName(name); err != nil {
+		return EmailAttachment{}, err
+	}
+
+	br := bufio.NewReaderSize(r, 512)
+	head, err := br.Peek(512)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return EmailAttachment{}, fmt.Errorf("reading %s failed: %w", name, err)
+	}
+	return EmailAttachment{
+		MimeType: DetectContentType(name, head),
+		Name:     name,
+		Reader:   br,
+	}, nil
+}
+
+// attachmentCarrier is implemented by the requests holding attachments.
+type attachmentCarrier interface {
+	attachmentList() *[]EmailAttachment
+}
+
+func (o *BaseEmailOption) attachmentList() *[]EmailAttachment { return &o.Attachments }
+
+func (r *SendBatchTemplatedEmailReq) attachmentList() *[]EmailAttachment { return &r.Attachments }
+
+// detachStreams replaces the content of the attachments of payload backed
+// by a Reader with unique placeholders, returned along with their readers.
+// The attachments are copied first, so the caller's request is left as is.
+func detachStreams(payload any) map[string]io.Reader {
+	ac, ok := payload.(attachmentCarrier)
+	if !ok {
+		return nil
+	}
+
+	list := ac.attachmentList()
+	var streams map[string]io.Reader
+	for i, a := range *list {
+		if a.Reader == nil {
+			continue
+		}
+		if streams == nil {
+			streams = make(map[string]io.Reader)
+			*list = slices.Clone(*list)
+		}
+		token := "zeptomail-stream-" + rand.Text()
+		(*list)[i].Content = token
+		streams[token] = a.Reader
+	}
+	return streams
+}
+
+// streamBody returns a request body made of the encoded payload with each
+// placeholder of streams replaced by the base64 encoding of its reader,
+// produced as the body is read.
+func streamBody(encoded []byte, streams map[string]io.Reader) *streamReader {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeStreams(pw, encoded, streams))
+	}()
+	return &streamReader{ReadCloser: pr}
+}
+
+// streamReader remembers the first error reading its body, so that a
+// failed stream is reported even when the server answers the truncated
+// request.
+type streamReader struct {
+	io.ReadCloser
+
+	mu  sync.Mutex
+	err error
+}
+
+func (s *streamReader) Read(p []byte) (int, error) {
+	n, err := s.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		s.mu.Lock()
+		if s.err == nil {
+			s.err = err
+		}
+		s.mu.Unlock()
+	}
+	return n, err
+}
+
+// Err returns the first error reading the body, if any.
+func (s *streamReader) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func writeStreams(w io.Writer, encoded []byte, streams map[string]io.Reader) error {
+	for {
+		next, at := "", -1
+		for token := range streams {
+			if i := bytes.Index(encoded, []byte(token)); i >= 0 && (at < 0 || i < at) {
+				next, at = token, i
+			}
+		}
+		if at < 0 {
+			_, err := w.Write(encoded)
+			return err
+		}
+
+		if _, err := w.Write(encoded[:at]); err != nil {
+			return err
+		}
+		enc := base64.NewEncoder(base64.StdEncoding, w)
+		if _, err := io.Copy(enc, &sizeLimitReader{r: streams[next], n: MaxAttachmentSize}); err != nil {
+			return err
+		}
+		if err := enc.Close(); err != nil {
+			return err
+		}
+		encoded = encoded[at+len(next):]
+	}
+}
+
+// sizeLimitReader fails with ErrAttachmentTooLarge once more than n bytes
+// have been read.
+type sizeLimitReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *sizeLimitReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	if l.n -= int64(n); l.n < 0 {
+		// hold back the bytes over the limit
+		return n + int(l.n), ErrAttachmentTooLarge
+	}
+	return n, err
+}
@@ -0,0 +1,138 @@
+package zeptomail_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/blancsoft/go-zeptomail"
+)
+
+func streamedReq(attachments ...zeptomail.EmailAttachment) zeptomail.SendHTMLEmailReq {
+	return zeptomail.SendHTMLEmailReq{
+		BaseSendEmail: zeptomail.BaseSendEmail{
+			From:      sender,
+			To:        []zeptomail.SendEmailTo{{EmailAddress: receiver}},
+			MergeInfo: map[string]any{"name": "World"},
+		},
+		BaseEmailOption: zeptomail.BaseEmailOption{Attachments: attachments},
+		Subject:         emailSubject,
+		HtmlBody:        emailBody,
+	}
+}
+
+func TestStreamAttachment(t *testing.T) {
+	var received zeptomail.SendHTMLEmailReq
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		acceptHandler(w, r)
+	})
+	email := (*zeptomail.Email)(client)
+
+	pdf := bytes.Repeat([]byte("%PDF-1.7 stream "), 4096)
+	streamed, err := zeptomail.StreamAttachment("terms.pdf", bytes.NewReader(pdf))
+	require.NoError(t, err)
+	assert.Equal(t, "application/pdf", streamed.MimeType)
+
+	png := append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 64)...)
+	sniffed, err := zeptomail.StreamAttachment("logo", bytes.NewReader(png))
+	require.NoError(t, err)
+	assert.Equal(t, "image/png", sniffed.MimeType)
+
+	req := streamedReq(streamed, attachment[0], sniffed)
+	rv, err := email.SendHTMLEmail(t.Context(), req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, rv.RawResponse.StatusCode)
+
+	require.Len(t, received.Attachments, 3)
+	assert.Equal(t, base64.StdEncoding.EncodeToString(pdf), received.Attachments[0].Content)
+	assert.Equal(t, attachment[0].Content, received.Attachments[1].Content)
+	assert.Equal(t, base64.StdEncoding.EncodeToString(png), received.Attachments[2].Content)
+	assert.Empty(t, req.Attachments[0].Content, "caller's request is left untouched")
+
+	_, err = zeptomail.StreamAttachment("tool.exe", bytes.NewReader(nil))
+	assert.ErrorIs(t, err, zeptomail.ErrBlockedAttachment)
+}
+
+func TestStreamAttachmentTooLarge(t *testing.T) {
+	var (
+		received []byte
+		complete atomic.Bool
+	)
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		received, err = io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		complete.Store(true)
+		acceptHandler(w, r)
+	})
+	email := (*zeptomail.Email)(client)
+
+	big, err := zeptomail.StreamAttachment("big.bin", bytes.NewReader(make([]byte, zeptomail.MaxAttachmentSize+1)))
+	require.NoError(t, err)
+	_, err = email.SendHTMLEmail(t.Context(), streamedReq(big))
+	require.ErrorIs(t, err, zeptomail.ErrAttachmentTooLarge)
+	assert.False(t, complete.Load(), "the server received a complete request")
+
+	_, content, found := bytes.Cut(received, []byte(`"content":"`))
+	require.True(t, found)
+	if end := bytes.IndexByte(content, '"'); end >= 0 {
+		content = content[:end]
+	}
+	assert.LessOrEqual(t, len(content), base64.StdEncoding.EncodedLen(zeptomail.MaxAttachmentSize),
+		"more than MaxAttachmentSize reached the server")
+}
+
+// discardClient returns a client whose requests are read to their end and
+// answered without going over the network.
+func discardClient(b *testing.B) *zeptomail.Email {
+	transport := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		return &http.Response{
+			StatusCode: http.StatusCreated,
+			Header:     http.Header{"Content-Type": {"application/json"}},
+			Body:       io.NopCloser(bytes.NewBufferString(sendAccepted)),
+		}, nil
+	})
+	client, err := zeptomail.NewClient("bench-agent", "Zoho-enczapikey bench", &http.Client{Transport: transport})
+	require.NoError(b, err)
+	return (*zeptomail.Email)(client)
+}
+
+// BenchmarkAttachment compares sending a 10 MB attachment held in memory as
+// base64 with streaming it from a reader.
+func BenchmarkAttachment(b *testing.B) {
+	data := make([]byte, 10<<20)
+
+	b.Run("inline", func(b *testing.B) {
+		email := discardClient(b)
+		b.ReportAllocs()
+		for b.Loop() {
+			a, err := zeptomail.AttachmentFromBytes("report.pdf", data)
+			require.NoError(b, err)
+			_, err = email.SendHTMLEmail(b.Context(), streamedReq(a))
+			require.NoError(b, err)
+		}
+	})
+
+	b.Run("streamed", func(b *testing.B) {
+		email := discardClient(b)
+		b.ReportAllocs()
+		for b.Loop() {
+			a, err := zeptomail.StreamAttachment("report.pdf", bytes.NewReader(data))
+			require.NoError(b, err)
+			_, err = email.SendHTMLEmail(b.Context(), streamedReq(a))
+			require.NoError(b, err)
+		}
+	})
+}
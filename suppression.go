@@ -0,0 +1,567 @@
+package zeptomail
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SuppressionReason tells why an address is suppressed.
+type SuppressionReason string
+
+const (
+	// SuppressedHardBounce is for addresses that permanently failed delivery.
+	SuppressedHardBounce SuppressionReason = "hard_bounce"
+
+	// SuppressedComplaint is for recipients who reported an email as spam.
+	SuppressedComplaint SuppressionReason = "complaint"
+
+	// SuppressedUnsubscribe is for recipients who unsubscribed.
+	SuppressedUnsubscribe SuppressionReason = "unsubscribe"
+
+	// SuppressedManual is for addresses suppressed by hand.
+	SuppressedManual SuppressionReason = "manual"
+)
+
+// Suppression is an address that must not be emailed.
+type Suppression struct {
+	Address   string            `json:"address"`
+	Reason    SuppressionReason `json:"reason"`
+	CreatedAt time.Time         `json:"created_at"`
+
+	// When the suppression lapses; the zero time never does
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+}
+
+// Expired reports whether s lapsed at now.
+func (s Suppression) Expired(now time.Time) bool {
+	return !s.ExpiresAt.IsZero() && !now.Before(s.ExpiresAt)
+}
+
+// SuppressionStore keeps the suppressed addresses, keyed by their address
+// normalized with NormalizeAddress and lower cased. Get returns nil for
+// addresses that are not suppressed, or whose suppression expired.
+type SuppressionStore interface {
+	Get(ctx context.Context, address string) (*Suppression, error)
+	Put(ctx context.Context, s Suppression) error
+	Delete(ctx context.Context, address string) error
+	List(ctx context.Context) ([]Suppression, error)
+}
+
+// suppressionKey is the address suppressions are stored under: addresses
+// are matched whatever their case, surrounding spaces or the encoding of
+// their domain. Addresses NormalizeAddress rejects are only lower cased.
+func suppressionKey(address string) string {
+	address = strings.TrimSpace(address)
+	if normalized, err := NormalizeAddress(address); err == nil {
+		address = normalized
+	}
+	return strings.ToLower(address)
+}
+
+// SuppressionMode is what a SuppressionList does with an email to
+// suppressed recipients.
+type SuppressionMode int
+
+const (
+	// SuppressionFilter drops the suppressed recipients and sends to the
+	// others. Emails left without a To recipient fail with a
+	// *SuppressedError.
+	SuppressionFilter SuppressionMode = iota
+
+	// SuppressionReject fails emails to any suppressed recipient with a
+	// *SuppressedError.
+	SuppressionReject
+)
+
+// SuppressedError is returned for emails that were not sent because of
+// suppressed recipients.
+type SuppressedError struct {
+	Suppressions []Suppression
+}
+
+func (e *SuppressedError) Error() string {
+	addrs := make([]string, len(e.Suppressions))
+	for i, s := range e.Suppressions {
+		addrs[i] = fmt.Sprintf("%s (%s)", s.Address, s.Reason)
+	}
+	return "suppressed recipients: " + strings.Join(addrs, ", ")
+}
+
+// SuppressionList checks recipients against a SuppressionStore.
+type SuppressionList struct {
+	store SuppressionStore
+
+	// optOuts holds the opt-outs of single lists, keyed by "list:address"
+	optOuts SuppressionStore
+}
+
+// NewSuppressionList returns a SuppressionList backed by store. Opt-outs
+// of single lists, see OptOut, are kept apart from the suppressions, in
+// listOptOuts; without one, they are kept in memory.
+func NewSuppressionList(store SuppressionStore, listOptOuts ...SuppressionStore) *SuppressionList {
+	l := &SuppressionList{store: store}
+	if len(listOptOuts) > 0 && listOptOuts[0] != nil {
+		l.optOuts = listOptOuts[0]
+	} else {
+		l.optOuts = NewMemorySuppressionStore()
+	}
+	return l
+}
+
+// Suppress suppresses address for reason, for ttl or, if ttl is 0, until
+// it is removed.
+func (l *SuppressionList) Suppress(ctx context.Context, address string, reason SuppressionReason, ttl time.Duration) error {
+	now := time.Now()
+	s := Suppression{Address: suppressionKey(address), Reason: reason, CreatedAt: now}
+	if ttl != 0 {
+		s.ExpiresAt = now.Add(ttl)
+	}
+	return l.store.Put(ctx, s)
+}
+
+// Remove lifts the suppression of address.
+func (l *SuppressionList) Remove(ctx context.Context, address string) error {
+	return l.store.Delete(ctx, suppressionKey(address))
+}
+
+// Lookup returns the suppression of address, or nil if it may be emailed.
+func (l *SuppressionList) Lookup(ctx context.Context, address string) (*Suppression, error) {
+	s, err := l.store.Get(ctx, suppressionKey(address))
+	if err != nil || s == nil || s.Expired(time.Now()) {
+		return nil, err
+	}
+	return s, nil
+}
+
+// OptOut implements OptOutStore. Opting out of every list suppresses
+// address as SuppressedUnsubscribe. Opting out of a single list is kept in
+// the list opt-out store, which only IsOptedOut checks, so that other
+// emails still reach address and the suppressions, e.g. as exported by
+// ExportCSV, only hold real addresses.
+func (l *SuppressionList) OptOut(ctx context.Context, address, list string) error {
+	if list == "" {
+		return l.Suppress(ctx, address, SuppressedUnsubscribe, 0)
+	}
+	return l.optOuts.Put(ctx, Suppression{
+		Address:   list + ":" + suppressionKey(address),
+		Reason:    SuppressedUnsubscribe,
+		CreatedAt: time.Now(),
+	})
+}
+
+// IsOptedOut implements OptOutStore. Addresses suppressed for any reason
+// are opted out of every list.
+func (l *SuppressionList) IsOptedOut(ctx context.Context, address, list string) (bool, error) {
+	if list == "" {
+		s, err := l.Lookup(ctx, address)
+		return s != nil, err
+	}
+	s, err := l.optOuts.Get(ctx, list+":"+suppressionKey(address))
+	return s != nil && !s.Expired(time.Now()), err
+}
+
+var suppressionCSVHeader = []string{"address", "reason", "created_at", "expires_at"}
+
+// ImportCSV adds the suppressions read from r, returning how many it
+// added. Rows are address, reason, created_at and expires_at, the times in
+// RFC 3339; all but the address may be left empty or out, the reason then
+// being SuppressedManual and the suppression never expiring. A header row
+// starting with "address" is skipped.
+func (l *SuppressionList) ImportCSV(ctx context.Context, r io.Reader) (int, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	n := 0
+	for line := 1; ; line++ {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		if line == 1 && strings.EqualFold(strings.TrimSpace(row[0]), "address") {
+			continue
+		}
+
+		s, err := parseSuppressionRow(row, time.Now())
+		if err != nil {
+			return n, fmt.Errorf("line %d: %w", line, err)
+		}
+		if err = l.store.Put(ctx, s); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+func parseSuppressionRow(row []string, now time.Time) (Suppression, error) {
+	field := func(i int) string {
+		if i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+
+	s := Suppression{
+		Address:   suppressionKey(field(0)),
+		Reason:    SuppressionReason(field(1)),
+		CreatedAt: now,
+	}
+	if !strings.Contains(s.Address, "@") {
+		return s, fmt.Errorf("invalid address %q", field(0))
+	}
+	if s.Reason == "" {
+		s.Reason = SuppressedManual
+	}
+	var err error
+	if v := field(2); v != "" {
+		if s.CreatedAt, err = time.Parse(time.RFC3339, v); err != nil {
+			return s, err
+		}
+	}
+	if v := field(3); v != "" {
+		if s.ExpiresAt, err = time.Parse(time.RFC3339, v); err != nil {
+			return s, err
+		}
+	}
+	return s, nil
+}
+
+// ExportCSV writes the suppressions that have not expired to w, sorted by
+// address, in the format read by ImportCSV.
+func (l *SuppressionList) ExportCSV(ctx context.Context, w io.Writer) error {
+	list, err := l.store.List(ctx)
+	if err != nil {
+		return err
+	}
+	slices.SortFunc(list, func(a, b Suppression) int { return strings.Compare(a.Address, b.Address) })
+
+	cw := csv.NewWriter(w)
+	if err = cw.Write(suppressionCSVHeader); err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, s := range list {
+		if s.Expired(now) {
+			continue
+		}
+		var expiresAt string
+		if !s.ExpiresAt.IsZero() {
+			expiresAt = s.ExpiresAt.UTC().Format(time.RFC3339)
+		}
+		row := []string{s.Address, string(s.Reason), s.CreatedAt.UTC().Format(time.RFC3339), expiresAt}
+		if err = cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Transformer returns a Transformer checking every To, CC and BCC
+// recipient against l, as mode says. report, if not nil, is called with
+// the suppressions of the recipients dropped from emails that are still
+// sent.
+func (l *SuppressionList) Transformer(mode SuppressionMode, report func(ctx context.Context, msg *OutgoingMessage, dropped []Suppression)) Transformer {
+	return func(ctx context.Context, msg *OutgoingMessage) error {
+		var (
+			dropped []Suppression
+			err     error
+		)
+		keep := func(a EmailAddress) bool {
+			if err != nil {
+				return true
+			}
+			var s *Suppression
+			if s, err = l.Lookup(ctx, a.Address); s != nil {
+				dropped = append(dropped, *s)
+			}
+			return s == nil
+		}
+
+		var to []OutgoingRecipient
+		for _, r := range msg.To {
+			if keep(r.EmailAddress) {
+				to = append(to, r)
+			}
+		}
+		cc, bcc := filterAddresses(msg.CC, keep), filterAddresses(msg.BCC, keep)
+		if err != nil {
+			return fmt.Errorf("checking suppressions failed: %w", err)
+		}
+		if len(dropped) == 0 {
+			return nil
+		}
+		if mode == SuppressionReject || len(to) == 0 {
+			return &SuppressedError{Suppressions: dropped}
+		}
+
+		msg.To, msg.CC, msg.BCC = to, cc, bcc
+		if report != nil {
+			report(ctx, msg, dropped)
+		}
+		return nil
+	}
+}
+
+// UseSuppressionList checks every email sent through e against list, so
+// that no email is sent to a suppressed address. The check runs after the
+// transformers of e, whatever the order they were added in, so that it
+// also covers the recipients they add. See SuppressionList.Transformer.
+func (e *Email) UseSuppressionList(list *SuppressionList, mode SuppressionMode, report func(ctx context.Context, msg *OutgoingMessage, dropped []Suppression)) {
+	e.suppression = list.Transformer(mode, report)
+}
+
+// MemorySuppressionStore is a SuppressionStore keeping suppressions in
+// memory.
+type MemorySuppressionStore struct {
+	mu           sync.RWMutex
+	suppressions map[string]Suppression
+}
+
+// NewMemorySuppressionStore returns an empty MemorySuppressionStore.
+func NewMemorySuppressionStore() *MemorySuppressionStore {
+	return &MemorySuppressionStore{suppressions: make(map[string]Suppression)}
+}
+
+// Get implements SuppressionStore.
+func (s *MemorySuppressionStore) Get(_ context.Context, address string) (*Suppression, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sup, ok := s.suppressions[address]
+	if !ok || sup.Expired(time.Now()) {
+		return nil, nil
+	}
+	return &sup, nil
+}
+
+// Put implements SuppressionStore.
+func (s *MemorySuppressionStore) Put(_ context.Context, sup Suppression) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.suppressions[sup.Address] = sup
+	return nil
+}
+
+// Delete implements SuppressionStore.
+func (s *MemorySuppressionStore) Delete(_ context.Context, address string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.suppressions, address)
+	return nil
+}
+
+// List implements SuppressionStore.
+func (s *MemorySuppressionStore) List(context.Context) ([]Suppression, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rv := make([]Suppression, 0, len(s.suppressions))
+	for _, sup := range s.suppressions {
+		rv = append(rv, sup)
+	}
+	return rv, nil
+}
+
+// FileSuppressionStore is a SuppressionStore kept in memory and saved to a
+// JSON file on every change.
+type FileSuppressionStore struct {
+	path string
+	mem  *MemorySuppressionStore
+	mu   sync.Mutex
+}
+
+// NewFileSuppressionStore returns a FileSuppressionStore saved to path,
+// loading the suppressions it already holds.
+func NewFileSuppressionStore(path string) (*FileSuppressionStore, error) {
+	s := &FileSuppressionStore{path: path, mem: NewMemorySuppressionStore()}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var list []Suppression
+	if err = json.Unmarshal(b, &list); err != nil {
+		return nil, fmt.Errorf("decoding %s failed: %w", path, err)
+	}
+	for _, sup := range list {
+		s.mem.suppressions[sup.Address] = sup
+	}
+	return s, nil
+}
+
+// Get implements SuppressionStore.
+func (s *FileSuppressionStore) Get(ctx context.Context, address string) (*Suppression, error) {
+	return s.mem.Get(ctx, address)
+}
+
+// List implements SuppressionStore.
+func (s *FileSuppressionStore) List(ctx context.Context) ([]Suppression, error) {
+	return s.mem.List(ctx)
+}
+
+// Put implements SuppressionStore.
+func (s *FileSuppressionStore) Put(ctx context.Context, sup Suppression) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.mem.Put(ctx, sup); err != nil {
+		return err
+	}
+	return s.save(ctx)
+}
+
+// Delete implements SuppressionStore.
+func (s *FileSuppressionStore) Delete(ctx context.Context, address string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.mem.Delete(ctx, address); err != nil {
+		return err
+	}
+	return s.save(ctx)
+}
+
+// Prune deletes the expired suppressions.
+func (s *FileSuppressionStore) Prune(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mem.mu.Lock()
+	now := time.Now()
+	for address, sup := range s.mem.suppressions {
+		if sup.Expired(now) {
+			delete(s.mem.suppressions, address)
+		}
+	}
+	s.mem.mu.Unlock()
+	return s.save(ctx)
+}
+
+func (s *FileSuppressionStore) save(ctx context.Context) error {
+	list, err := s.mem.List(ctx)
+	if err != nil {
+		return err
+	}
+	slices.SortFunc(list, func(a, b Suppression) int { return strings.Compare(a.Address, b.Address) })
+
+	b, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, b)
+}
+
+// SQLSuppressionStore is a SuppressionStore backed by a database table,
+// which lets several processes share their suppressions. The table must
+// have the following columns, expires_at being 0 for suppressions that
+// never expire:
+//
+//	CREATE TABLE zeptomail_suppressions (
+//		address    VARCHAR(320) PRIMARY KEY,
+//		reason     VARCHAR(32) NOT NULL,
+//		created_at BIGINT      NOT NULL,
+//		expires_at BIGINT      NOT NULL
+//	)
+type SQLSuppressionStore struct {
+	table sqlTable
+}
+
+// NewSQLSuppressionStore returns a SQLSuppressionStore using the given
+// table. Statements bind arguments with QuestionPlaceholder unless another
+// Placeholder is given.
+func NewSQLSuppressionStore(db *sql.DB, table string, placeholder ...Placeholder) (*SQLSuppressionStore, error) {
+	t, err := newSQLTable(db, table, placeholder)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLSuppressionStore{table: t}, nil
+}
+
+// Get implements SuppressionStore.
+func (s *SQLSuppressionStore) Get(ctx context.Context, address string) (*Suppression, error) {
+	q := s.table.query("SELECT address, reason, created_at, expires_at FROM %s WHERE address = %s", 1)
+	sup, err := scanSuppression(s.table.db.QueryRowContext(ctx, q, address))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil || sup.Expired(time.Now()) {
+		return nil, err
+	}
+	return &sup, nil
+}
+
+// Put implements SuppressionStore.
+func (s *SQLSuppressionStore) Put(ctx context.Context, sup Suppression) error {
+	var expiresAt int64
+	if !sup.ExpiresAt.IsZero() {
+		expiresAt = sup.ExpiresAt.UnixNano()
+	}
+	return s.table.replace(ctx,
+		s.table.query("DELETE FROM %s WHERE address = %s", 1), []any{sup.Address},
+		s.table.query("INSERT INTO %s (address, reason, created_at, expires_at) VALUES (%s, %s, %s, %s)", 4),
+		[]any{sup.Address, string(sup.Reason), sup.CreatedAt.UnixNano(), expiresAt},
+	)
+}
+
+// Delete implements SuppressionStore.
+func (s *SQLSuppressionStore) Delete(ctx context.Context, address string) error {
+	_, err := s.table.db.ExecContext(ctx, s.table.query("DELETE FROM %s WHERE address = %s", 1), address)
+	return err
+}
+
+// List implements SuppressionStore.
+func (s *SQLSuppressionStore) List(ctx context.Context) ([]Suppression, error) {
+	rows, err := s.table.db.QueryContext(ctx, s.table.query("SELECT address, reason, created_at, expires_at FROM %s", 0))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rv []Suppression
+	for rows.Next() {
+		sup, err := scanSuppression(rows)
+		if err != nil {
+			return nil, err
+		}
+		rv = append(rv, sup)
+	}
+	return rv, rows.Err()
+}
+
+// Prune deletes the expired suppressions.
+func (s *SQLSuppressionStore) Prune(ctx context.Context) error {
+	q := s.table.query("DELETE FROM %s WHERE expires_at > 0 AND expires_at <= %s", 1)
+	_, err := s.table.db.ExecContext(ctx, q, time.Now().UnixNano())
+	return err
+}
+
+func scanSuppression(row interface{ Scan(...any) error }) (Suppression, error) {
+	var (
+		sup                  Suppression
+		reason               string
+		createdAt, expiresAt int64
+	)
+	if err := row.Scan(&sup.Address, &reason, &createdAt, &expiresAt); err != nil {
+		return sup, err
+	}
+	sup.Reason = SuppressionReason(reason)
+	sup.CreatedAt = time.Unix(0, createdAt)
+	if expiresAt != 0 {
+		sup.ExpiresAt = time.Unix(0, expiresAt)
+	}
+	return sup, nil
+}
@@ -0,0 +1,183 @@
+package zeptomail_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/blancsoft/go-zeptomail"
+)
+
+func TestSuppressionList(t *testing.T) {
+	list := zeptomail.NewSuppressionList(zeptomail.NewMemorySuppressionStore())
+	require.NoError(t, list.Suppress(t.Context(), "Bounced@Example.com", zeptomail.SuppressedHardBounce, 0))
+	require.NoError(t, list.Suppress(t.Context(), "lapsed@example.com", zeptomail.SuppressedComplaint, -time.Minute))
+
+	s, err := list.Lookup(t.Context(), " bounced@example.COM")
+	require.NoError(t, err)
+	require.NotNil(t, s)
+	assert.Equal(t, zeptomail.SuppressedHardBounce, s.Reason)
+
+	s, err = list.Lookup(t.Context(), "lapsed@example.com")
+	require.NoError(t, err)
+	assert.Nil(t, s, "expired suppressions are ignored")
+
+	require.NoError(t, list.Remove(t.Context(), "BOUNCED@example.com"))
+	s, err = list.Lookup(t.Context(), "bounced@example.com")
+	require.NoError(t, err)
+	assert.Nil(t, s)
+}
+
+func TestSuppressionListNormalizesDomains(t *testing.T) {
+	list := zeptomail.NewSuppressionList(zeptomail.NewMemorySuppressionStore())
+	require.NoError(t, list.Suppress(t.Context(), "jane@Bücher.example", zeptomail.SuppressedHardBounce, 0))
+
+	s, err := list.Lookup(t.Context(), "Jane@xn--bcher-kva.example")
+	require.NoError(t, err)
+	require.NotNil(t, s, "punycode matches the unicode domain")
+	assert.Equal(t, "jane@xn--bcher-kva.example", s.Address)
+
+	require.NoError(t, list.Remove(t.Context(), "jane@bücher.example"))
+	s, err = list.Lookup(t.Context(), "jane@xn--bcher-kva.example")
+	require.NoError(t, err)
+	assert.Nil(t, s)
+}
+
+func TestSuppressionCSV(t *testing.T) {
+	list := zeptomail.NewSuppressionList(zeptomail.NewMemorySuppressionStore())
+	n, err := list.ImportCSV(t.Context(), strings.NewReader(`address,reason,created_at,expires_at
+Ada@example.com,complaint,2024-01-02T03:04:05Z,
+bob@example.com
+carol@example.com,unsubscribe,2024-01-02T03:04:05Z,2999-01-01T00:00:00Z
+`))
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+
+	s, err := list.Lookup(t.Context(), "bob@example.com")
+	require.NoError(t, err)
+	require.NotNil(t, s)
+	assert.Equal(t, zeptomail.SuppressedManual, s.Reason)
+
+	var out strings.Builder
+	require.NoError(t, list.ExportCSV(t.Context(), &out))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 4)
+	assert.Equal(t, "address,reason,created_at,expires_at", lines[0])
+	assert.Equal(t, "ada@example.com,complaint,2024-01-02T03:04:05Z,", lines[1])
+	assert.Equal(t, "carol@example.com,unsubscribe,2024-01-02T03:04:05Z,2999-01-01T00:00:00Z", lines[3])
+
+	_, err = list.ImportCSV(t.Context(), strings.NewReader("not-an-address,manual\n"))
+	assert.ErrorContains(t, err, "line 1")
+}
+
+func TestFileSuppressionStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "suppressions.json")
+	store, err := zeptomail.NewFileSuppressionStore(path)
+	require.NoError(t, err)
+	list := zeptomail.NewSuppressionList(store)
+	require.NoError(t, list.Suppress(t.Context(), "ada@example.com", zeptomail.SuppressedUnsubscribe, 0))
+	require.NoError(t, list.Suppress(t.Context(), "bob@example.com", zeptomail.SuppressedHardBounce, time.Nanosecond))
+	time.Sleep(time.Millisecond)
+	require.NoError(t, store.Prune(t.Context()))
+
+	reopened, err := zeptomail.NewFileSuppressionStore(path)
+	require.NoError(t, err)
+	all, err := reopened.List(t.Context())
+	require.NoError(t, err)
+	require.Len(t, all, 1)
+	assert.Equal(t, "ada@example.com", all[0].Address)
+	assert.Equal(t, zeptomail.SuppressedUnsubscribe, all[0].Reason)
+}
+
+func TestSendSkipsSuppressedRecipients(t *testing.T) {
+	var sent map[string]any
+	email := (*zeptomail.Email)(newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		sent = nil
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&sent))
+		acceptHandler(w, r)
+	}))
+
+	list := zeptomail.NewSuppressionList(zeptomail.NewMemorySuppressionStore())
+	require.NoError(t, list.Suppress(t.Context(), other.Address, zeptomail.SuppressedComplaint, 0))
+
+	var dropped []zeptomail.Suppression
+	email.UseSuppressionList(list, zeptomail.SuppressionFilter, func(_ context.Context, _ *zeptomail.OutgoingMessage, d []zeptomail.Suppression) {
+		dropped = append(dropped, d...)
+	})
+
+	req := zeptomail.SendBatchHTMLEmailReq{
+		From: sender,
+		To: []zeptomail.SendBatchEmailTo{
+			{EmailAddress: receiver, MergeInfo: map[string]any{"name": "Ada"}},
+			{EmailAddress: other, MergeInfo: map[string]any{"name": "Bob"}},
+		},
+		Subject:  emailSubject,
+		HtmlBody: emailBody,
+	}
+	_, err := email.SendBatchHTMLEmail(t.Context(), req)
+	require.NoError(t, err)
+	to := sent["to"].([]any)
+	require.Len(t, to, 1)
+	assert.Equal(t, receiver.Address, to[0].(map[string]any)["email_address"].(map[string]any)["address"])
+	require.Len(t, dropped, 1)
+	assert.Equal(t, zeptomail.SuppressedComplaint, dropped[0].Reason)
+
+	sent = nil
+	req.To = req.To[1:]
+	_, err = email.SendBatchHTMLEmail(t.Context(), req)
+	var se *zeptomail.SuppressedError
+	require.ErrorAs(t, err, &se)
+	assert.Len(t, se.Suppressions, 1)
+	assert.Nil(t, sent, "nothing is sent when every recipient is suppressed")
+}
+
+func TestSuppressionRunsAfterTransformers(t *testing.T) {
+	var sent map[string]any
+	email := (*zeptomail.Email)(newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		sent = nil
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&sent))
+		acceptHandler(w, r)
+	}))
+
+	list := zeptomail.NewSuppressionList(zeptomail.NewMemorySuppressionStore())
+	require.NoError(t, list.Suppress(t.Context(), other.Address, zeptomail.SuppressedComplaint, 0))
+	email.UseSuppressionList(list, zeptomail.SuppressionFilter, nil)
+	email.UseTransformers(func(_ context.Context, msg *zeptomail.OutgoingMessage) error {
+		msg.BCC = append(msg.BCC, other)
+		return nil
+	})
+
+	_, err := email.SendHTMLEmail(t.Context(), zeptomail.SendHTMLEmailReq{
+		BaseSendEmail: zeptomail.BaseSendEmail{
+			From:      sender,
+			To:        []zeptomail.SendEmailTo{{EmailAddress: receiver}},
+			MergeInfo: map[string]any{},
+		},
+		Subject:  emailSubject,
+		HtmlBody: emailBody,
+	})
+	require.NoError(t, err)
+	assert.NotContains(t, sent, "bcc", "recipients added by later transformers are checked")
+}
+
+func TestSuppressionReject(t *testing.T) {
+	list := zeptomail.NewSuppressionList(zeptomail.NewMemorySuppressionStore())
+	require.NoError(t, list.Suppress(t.Context(), "bcc@example.com", zeptomail.SuppressedHardBounce, 0))
+
+	transform := list.Transformer(zeptomail.SuppressionReject, nil)
+	msg := &zeptomail.OutgoingMessage{
+		To:  []zeptomail.OutgoingRecipient{{EmailAddress: zeptomail.EmailAddress{Address: "ada@example.com"}}},
+		BCC: []zeptomail.EmailAddress{{Address: "bcc@example.com"}},
+	}
+	var se *zeptomail.SuppressedError
+	require.ErrorAs(t, transform(t.Context(), msg), &se)
+	assert.Equal(t, "bcc@example.com", se.Suppressions[0].Address)
+	assert.Len(t, msg.BCC, 1, "rejected messages are left as they are")
+}
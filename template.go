@@ -10,6 +10,11 @@ type Template Client
 
 // AddEmailTemplate is used to add an email template.
 func (t *Template) AddEmailTemplate(ctx context.Context, req AddEmailTemplateReq) (*WrappedResponse[AddEmailTemplateRes], error) {
+	if t.cssInlining != nil {
+		if err := t.cssInlining.inline(&req.HtmlBody); err != nil {
+			return nil, err
+		}
+	}
 	path := fmt.Sprintf("/mailagents/%s/templates", t.mailAgent)
 	endpoint := t.baseURL.JoinPath(path)
 	return request[AddEmailTemplateReq, AddEmailTemplateRes]((*Client)(t), ctx, http.MethodPost, endpoint, nil, req)
@@ -17,6 +22,11 @@ func (t *Template) AddEmailTemplate(ctx context.Context, req AddEmailTemplateReq
 
 // UpdateEmailTemplate is used to update an email template.
 func (t *Template) UpdateEmailTemplate(ctx context.Context, req UpdateEmailTemplateReq) (*WrappedResponse[AddEmailTemplateRes], error) {
+	if t.cssInlining != nil {
+		if err := t.cssInlining.inline(&req.HtmlBody); err != nil {
+			return nil, err
+		}
+	}
 	path := fmt.Sprintf("/mailagents/%s/templates/%s", t.mailAgent, req.TemplateKey)
 	endpoint := t.baseURL.JoinPath(path)
 	return request[UpdateEmailTemplateReq, AddEmailTemplateRes]((*Client)(t), ctx, http.MethodPut, endpoint, nil, req)
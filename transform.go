@@ -0,0 +1,270 @@
+package zeptomail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// MessageKind tells which send request an OutgoingMessage is a view of.
+type MessageKind int
+
+const (
+	HTMLMessage           MessageKind = iota // SendHTMLEmailReq
+	BatchHTMLMessage                         // SendBatchHTMLEmailReq
+	TemplatedMessage                         // SendTemplatedEmailReq
+	BatchTemplatedMessage                    // SendBatchTemplatedEmailReq
+)
+
+func (k MessageKind) String() string {
+	switch k {
+	case HTMLMessage:
+		return "html"
+	case BatchHTMLMessage:
+		return "batch html"
+	case TemplatedMessage:
+		return "templated"
+	case BatchTemplatedMessage:
+		return "batch templated"
+	}
+	return fmt.Sprintf("MessageKind(%d)", int(k))
+}
+
+type (
+	// OutgoingMessage is the view of a send request transformers work on,
+	// the same for the four kinds of request. Fields the request has no
+	// counterpart for are left empty, and changes to them are ignored:
+	// e.g. Subject for templated emails, or MergeInfo for batch emails,
+	// whose merge info is per recipient.
+	OutgoingMessage struct {
+		Kind MessageKind
+
+		// Empty for HTML emails
+		TemplateKey string
+
+		From    EmailAddress
+		To      []OutgoingRecipient
+		CC      []EmailAddress
+		BCC     []EmailAddress
+		ReplyTo []EmailAddress
+
+		Subject  string
+		HtmlBody string
+		TextBody string
+
+		// Merge info of single emails
+		MergeInfo map[string]any
+
+		// nil for the Mail Agent setting
+		TrackClicks     *bool
+		TrackOpens      *bool
+		ClientReference string
+		MimeHeaders     Headers
+		Attachments     []EmailAttachment
+		InlineImages    []InlineImage
+	}
+
+	// OutgoingRecipient is a To recipient of an OutgoingMessage.
+	OutgoingRecipient struct {
+		EmailAddress
+
+		// Merge info of the recipient of a batch email
+		MergeInfo map[string]any
+	}
+
+	// Transformer changes an email before it is sent. Returning an error
+	// stops the send; return a *VetoError, see Veto, for sends refused on
+	// purpose.
+	Transformer func(ctx context.Context, msg *OutgoingMessage) error
+)
+
+// VetoError is returned by sends a Transformer refused.
+type VetoError struct {
+	Reason string
+}
+
+func (e *VetoError) Error() string {
+	return "send vetoed: " + e.Reason
+}
+
+// Veto returns a *VetoError for reason, for a Transformer to refuse a send.
+func Veto(reason string) error {
+	return &VetoError{Reason: reason}
+}
+
+// IsVetoed reports whether err is, or wraps, a *VetoError.
+func IsVetoed(err error) bool {
+	var v *VetoError
+	return errors.As(err, &v)
+}
+
+// UseTransformers adds transformers to the ones every send goes through,
+// in order, before the request is validated and encoded.
+func (e *Email) UseTransformers(transformers ...Transformer) {
+	e.transformers = append(e.transformers, transformers...)
+}
+
+// transform runs the transformers of e on req, a pointer to a send request,
+// followed by the suppression list and the sandbox.
+func transform(e *Email, ctx context.Context, req any) error {
+	steps := e.transformers
+	for _, t := range []Transformer{e.suppression, e.sandbox} {
+		if t != nil {
+			steps = append(slices.Clip(steps), t)
+		}
+	}
+	if len(steps) == 0 {
+		return nil
+	}
+	msg := newOutgoingMessage(req)
+	for _, t := range steps {
+		if err := t(ctx, msg); err != nil {
+			return err
+		}
+	}
+	msg.applyTo(req)
+	return nil
+}
+
+// newOutgoingMessage returns a view of req. Slices and maps are copied, so
+// changing the view leaves req as is.
+func newOutgoingMessage(req any) *OutgoingMessage {
+	m := &OutgoingMessage{}
+	switch r := req.(type) {
+	case *SendHTMLEmailReq:
+		m.Kind = HTMLMessage
+		m.fromBase(r.BaseSendEmail, r.BaseEmailOption)
+		m.Subject, m.HtmlBody, m.TextBody = r.Subject, r.HtmlBody, r.TextBody
+	case *SendBatchHTMLEmailReq:
+		m.Kind = BatchHTMLMessage
+		m.From = r.From
+		m.To = batchRecipients(r.To)
+		m.fromOptions(r.BaseEmailOption)
+		m.Subject, m.HtmlBody, m.TextBody = r.Subject, r.HtmlBody, r.TextBody
+	case *SendTemplatedEmailReq:
+		m.Kind = TemplatedMessage
+		m.fromBase(r.BaseSendEmail, r.BaseEmailOption)
+		m.TemplateKey = r.TemplateKey
+	case *SendBatchTemplatedEmailReq:
+		m.Kind = BatchTemplatedMessage
+		m.TemplateKey = r.TemplateKey
+		m.From = r.From
+		m.To = batchRecipients(r.To)
+		if r.ReplyTo != (EmailAddress{}) {
+			m.ReplyTo = []EmailAddress{r.ReplyTo}
+		}
+		m.TrackClicks, m.TrackOpens = r.TrackClicks, r.TrackOpens
+		m.ClientReference = r.ClientReference
+		m.MimeHeaders = maps.Clone(r.MimeHeaders)
+		m.Attachments = slices.Clone(r.Attachments)
+	}
+	return m
+}
+
+func (m *OutgoingMessage) fromBase(b BaseSendEmail, o BaseEmailOption) {
+	m.From = b.From
+	for _, to := range b.To {
+		m.To = append(m.To, OutgoingRecipient{EmailAddress: to.EmailAddress})
+	}
+	m.MergeInfo = maps.Clone(b.MergeInfo)
+	m.fromOptions(o)
+}
+
+func (m *OutgoingMessage) fromOptions(o BaseEmailOption) {
+	m.CC, m.BCC = addresses(o.CC), addresses(o.BCC)
+	m.ReplyTo = slices.Clone(o.ReplyTo)
+	m.TrackClicks, m.TrackOpens = o.TrackClicks, o.TrackOpens
+	m.ClientReference = o.ClientReference
+	m.MimeHeaders = maps.Clone(o.MimeHeaders)
+	m.Attachments = slices.Clone(o.Attachments)
+	m.InlineImages = slices.Clone(o.InlineImages)
+}
+
+// applyTo writes the view back to req.
+func (m *OutgoingMessage) applyTo(req any) {
+	switch r := req.(type) {
+	case *SendHTMLEmailReq:
+		m.toBase(&r.BaseSendEmail, &r.BaseEmailOption)
+		r.Subject, r.HtmlBody, r.TextBody = m.Subject, m.HtmlBody, m.TextBody
+	case *SendBatchHTMLEmailReq:
+		r.From = m.From
+		r.To = m.batchRecipients()
+		m.toOptions(&r.BaseEmailOption)
+		r.Subject, r.HtmlBody, r.TextBody = m.Subject, m.HtmlBody, m.TextBody
+	case *SendTemplatedEmailReq:
+		m.toBase(&r.BaseSendEmail, &r.BaseEmailOption)
+		r.TemplateKey = m.TemplateKey
+	case *SendBatchTemplatedEmailReq:
+		r.TemplateKey = m.TemplateKey
+		r.From = m.From
+		r.To = m.batchRecipients()
+		r.ReplyTo = EmailAddress{}
+		if len(m.ReplyTo) > 0 {
+			r.ReplyTo = m.ReplyTo[0]
+		}
+		r.TrackClicks, r.TrackOpens = m.TrackClicks, m.TrackOpens
+		r.ClientReference = m.ClientReference
+		r.MimeHeaders = m.MimeHeaders
+		r.Attachments = m.Attachments
+	}
+}
+
+func (m *OutgoingMessage) toBase(b *BaseSendEmail, o *BaseEmailOption) {
+	b.From = m.From
+	b.To = nil
+	for _, to := range m.To {
+		b.To = append(b.To, SendEmailTo{EmailAddress: to.EmailAddress})
+	}
+	b.MergeInfo = m.MergeInfo
+	m.toOptions(o)
+}
+
+func (m *OutgoingMessage) toOptions(o *BaseEmailOption) {
+	o.CC, o.BCC = sendEmailTo(m.CC), sendEmailTo(m.BCC)
+	o.ReplyTo = m.ReplyTo
+	o.TrackClicks, o.TrackOpens = m.TrackClicks, m.TrackOpens
+	o.ClientReference = m.ClientReference
+	o.MimeHeaders = m.MimeHeaders
+	o.Attachments = m.Attachments
+	o.InlineImages = m.InlineImages
+}
+
+func (m *OutgoingMessage) batchRecipients() []SendBatchEmailTo {
+	var to []SendBatchEmailTo
+	for _, r := range m.To {
+		to = append(to, SendBatchEmailTo{EmailAddress: r.EmailAddress, MergeInfo: r.MergeInfo})
+	}
+	return to
+}
+
+func batchRecipients(to []SendBatchEmailTo) []OutgoingRecipient {
+	var rv []OutgoingRecipient
+	for _, r := range to {
+		rv = append(rv, OutgoingRecipient{EmailAddress: r.EmailAddress, MergeInfo: maps.Clone(r.MergeInfo)})
+	}
+	return rv
+}
+
+func addresses(to []SendEmailTo) []EmailAddress {
+	if to == nil {
+		return nil
+	}
+	rv := make([]EmailAddress, len(to))
+	for i, r := range to {
+		rv[i] = r.EmailAddress
+	}
+	return rv
+}
+
+func sendEmailTo(addrs []EmailAddress) []SendEmailTo {
+	if addrs == nil {
+		return nil
+	}
+	rv := make([]SendEmailTo, len(addrs))
+	for i, a := range addrs {
+		rv[i] = SendEmailTo{EmailAddress: a}
+	}
+	return rv
+}
@@ -0,0 +1,96 @@
+package zeptomail_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/blancsoft/go-zeptomail"
+)
+
+func TestEmailUseTransformers(t *testing.T) {
+	var (
+		sent  map[string]any
+		sends int
+	)
+	email := (*zeptomail.Email)(newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		sends++
+		sent = nil
+		b, _ := io.ReadAll(r.Body)
+		require.NoError(t, json.Unmarshal(b, &sent))
+		acceptHandler(w, r)
+	}))
+
+	var kinds []zeptomail.MessageKind
+	email.UseTransformers(
+		func(_ context.Context, msg *zeptomail.OutgoingMessage) error {
+			kinds = append(kinds, msg.Kind)
+			for _, to := range msg.To {
+				if strings.HasSuffix(to.Address, "@blocked.example") {
+					return zeptomail.Veto("blocked domain " + to.Address)
+				}
+			}
+			return nil
+		},
+		func(_ context.Context, msg *zeptomail.OutgoingMessage) error {
+			msg.HtmlBody += "<p>footer</p>"
+			if msg.MimeHeaders == nil {
+				msg.MimeHeaders = zeptomail.Headers{}
+			}
+			msg.MimeHeaders.Set("X-Campaign", "spring")
+			for i := range msg.To {
+				if msg.To[i].MergeInfo != nil {
+					msg.To[i].MergeInfo["footer"] = true
+				}
+			}
+			return nil
+		},
+	)
+
+	req := zeptomail.SendHTMLEmailReq{
+		BaseSendEmail: zeptomail.BaseSendEmail{
+			From:      sender,
+			To:        []zeptomail.SendEmailTo{{EmailAddress: receiver}},
+			MergeInfo: map[string]any{"name": "Ada"},
+		},
+		Subject:  emailSubject,
+		HtmlBody: "<p>Hi</p>",
+	}
+	_, err := email.SendHTMLEmail(t.Context(), req)
+	require.NoError(t, err)
+	assert.Equal(t, "<p>Hi</p><p>footer</p>", sent["htmlbody"])
+	assert.Equal(t, map[string]any{"X-Campaign": "spring"}, sent["mime_headers"])
+	assert.Equal(t, "<p>Hi</p>", req.HtmlBody, "the caller's request is left as is")
+	assert.Nil(t, req.MimeHeaders)
+
+	batchInfo := map[string]any{"name": "Ada"}
+	_, err = email.SendBatchTemplatedEmail(t.Context(), zeptomail.SendBatchTemplatedEmailReq{
+		TemplateKey: "tpl",
+		From:        sender,
+		ReplyTo:     sender,
+		To:          []zeptomail.SendBatchEmailTo{{EmailAddress: receiver, MergeInfo: batchInfo}},
+	})
+	require.NoError(t, err)
+	to := sent["to"].([]any)[0].(map[string]any)
+	assert.Equal(t, map[string]any{"name": "Ada", "footer": true}, to["merge_info"])
+	assert.NotContains(t, batchInfo, "footer")
+	assert.NotContains(t, sent, "htmlbody", "templated emails have no body to change")
+
+	req.To = append(req.To, zeptomail.SendEmailTo{EmailAddress: zeptomail.EmailAddress{Address: "x@blocked.example"}})
+	_, err = email.SendHTMLEmail(t.Context(), req)
+	var veto *zeptomail.VetoError
+	require.ErrorAs(t, err, &veto)
+	assert.Equal(t, "blocked domain x@blocked.example", veto.Reason)
+	assert.True(t, zeptomail.IsVetoed(err))
+	assert.Equal(t, 2, sends)
+
+	assert.Equal(t, []zeptomail.MessageKind{
+		zeptomail.HTMLMessage, zeptomail.BatchTemplatedMessage, zeptomail.HTMLMessage,
+	}, kinds)
+}
@@ -0,0 +1,299 @@
+package zeptomail
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// ListUnsubscribeHeader lists the ways to unsubscribe, RFC 2369.
+	ListUnsubscribeHeader = "List-Unsubscribe"
+
+	// ListUnsubscribePostHeader marks the https List-Unsubscribe URL as
+	// supporting one-click unsubscribes, RFC 8058.
+	ListUnsubscribePostHeader = "List-Unsubscribe-Post"
+)
+
+// ErrInvalidUnsubscribeToken is returned for unsubscribe tokens that were
+// not signed with the secret of the Unsubscriber.
+var ErrInvalidUnsubscribeToken = errors.New("invalid unsubscribe token")
+
+// ListUnsubscribe are the unsubscribe headers of an email.
+type ListUnsubscribe struct {
+	// mailto: URL, or address, unsubscribe requests can be sent to
+	Mailto string
+
+	// https URL unsubscribing the recipient on a POST, see Unsubscriber
+	URL string
+}
+
+// Headers returns the List-Unsubscribe header, and the
+// List-Unsubscribe-Post one if l has a one-click URL.
+func (l ListUnsubscribe) Headers() (map[string]string, error) {
+	var uris []string
+	if l.Mailto != "" {
+		mailto := l.Mailto
+		if !strings.HasPrefix(strings.ToLower(mailto), "mailto:") {
+			mailto = "mailto:" + mailto
+		}
+		uris = append(uris, "<"+mailto+">")
+	}
+	if l.URL != "" {
+		u, err := url.Parse(l.URL)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return nil, fmt.Errorf("one-click unsubscribe URL %q is not an https URL", l.URL)
+		}
+		uris = append(uris, "<"+u.String()+">")
+	}
+	if len(uris) == 0 {
+		return nil, errors.New("no unsubscribe mailto or URL")
+	}
+
+	headers := map[string]string{ListUnsubscribeHeader: strings.Join(uris, ", ")}
+	if l.URL != "" {
+		headers[ListUnsubscribePostHeader] = "List-Unsubscribe=One-Click"
+	}
+	return headers, nil
+}
+
+// AddTo adds the unsubscribe headers to headers, e.g. the MimeHeaders of
+// a request, and returns it. headers is allocated if nil.
+func (l ListUnsubscribe) AddTo(headers Headers) (Headers, error) {
+	h, err := l.Headers()
+	if err != nil {
+		return headers, err
+	}
+	if headers == nil {
+		headers = make(Headers, len(h))
+	}
+	for k, v := range h {
+		headers.Set(k, v)
+	}
+	return headers, nil
+}
+
+// OptOutStore records the recipients who unsubscribed. The list "" stands
+// for every list. A *SuppressionList is an OptOutStore, so that opt-outs
+// and suppressions are kept together.
+type OptOutStore interface {
+	OptOut(ctx context.Context, address, list string) error
+	IsOptedOut(ctx context.Context, address, list string) (bool, error)
+}
+
+// UnsubscribeConfig configures an Unsubscriber.
+type UnsubscribeConfig struct {
+	// Key the unsubscribe tokens are signed with
+	Secret []byte `validate:"required"`
+
+	// https URL the Unsubscriber is served at
+	URL string `validate:"required,url"`
+
+	// Address unsubscribe requests can also be mailed to
+	Mailto string
+}
+
+// Unsubscriber issues signed unsubscribe links, records the opt-outs of
+// the recipients following them, and drops opted-out recipients from
+// outgoing emails. It is an http.Handler serving the unsubscribe links:
+// a POST, as sent by one-click unsubscribes, opts the recipient out, a GET
+// shows a page asking to confirm.
+type Unsubscriber struct {
+	store OptOutStore
+	cfg   UnsubscribeConfig
+}
+
+// NewUnsubscriber returns an Unsubscriber recording opt-outs in store.
+func NewUnsubscriber(store OptOutStore, cfg UnsubscribeConfig) (*Unsubscriber, error) {
+	if err := validate.Struct(&cfg); err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(cfg.URL, "https://") {
+		return nil, fmt.Errorf("unsubscribe URL %q is not an https URL", cfg.URL)
+	}
+	return &Unsubscriber{store: store, cfg: cfg}, nil
+}
+
+// Token returns the signed token unsubscribing address from list.
+func (u *Unsubscriber) Token(address, list string) string {
+	payload := strings.ToLower(strings.TrimSpace(address)) + "\x00" + list
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(u.sign(payload))
+}
+
+// Verify returns the address and list of a token made by Token.
+func (u *Unsubscriber) Verify(token string) (address, list string, err error) {
+	encoded, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", "", ErrInvalidUnsubscribeToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", "", ErrInvalidUnsubscribeToken
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, u.sign(string(payload))) {
+		return "", "", ErrInvalidUnsubscribeToken
+	}
+	address, list, _ = strings.Cut(string(payload), "\x00")
+	return address, list, nil
+}
+
+func (u *Unsubscriber) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, u.cfg.Secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)[:16]
+}
+
+// ListUnsubscribe returns the unsubscribe headers unsubscribing address
+// from list.
+func (u *Unsubscriber) ListUnsubscribe(address, list string) ListUnsubscribe {
+	token := u.Token(address, list)
+	link, _ := url.Parse(u.cfg.URL)
+	q := link.Query()
+	q.Set("token", token)
+	link.RawQuery = q.Encode()
+
+	l := ListUnsubscribe{URL: link.String()}
+	if u.cfg.Mailto != "" {
+		// RFC 6068 reads + as itself, not as a space
+		subject := strings.ReplaceAll(url.QueryEscape("unsubscribe "+token), "+", "%20")
+		l.Mailto = "mailto:" + u.cfg.Mailto + "?subject=" + subject
+	}
+	return l
+}
+
+// IsOptedOut reports whether address opted out of list, or of every list.
+func (u *Unsubscriber) IsOptedOut(ctx context.Context, address, list string) (bool, error) {
+	address = strings.ToLower(strings.TrimSpace(address))
+	if out, err := u.store.IsOptedOut(ctx, address, ""); out || err != nil || list == "" {
+		return out, err
+	}
+	return u.store.IsOptedOut(ctx, address, list)
+}
+
+// Transformer returns a Transformer dropping the recipients who opted out
+// of list, vetoing emails left with none. Emails to a single To recipient
+// get the unsubscribe headers for them. Emails to several To recipients,
+// such as batch emails, get no unsubscribe headers, since their headers
+// are shared by every recipient: link to a ListUnsubscribe URL from their
+// merge info instead.
+func (u *Unsubscriber) Transformer(list string) Transformer {
+	return func(ctx context.Context, msg *OutgoingMessage) error {
+		var err error
+		keep := func(a EmailAddress) bool {
+			if err != nil {
+				return true
+			}
+			var out bool
+			out, err = u.IsOptedOut(ctx, a.Address, list)
+			return !out
+		}
+
+		var to []OutgoingRecipient
+		for _, r := range msg.To {
+			if keep(r.EmailAddress) {
+				to = append(to, r)
+			}
+		}
+		cc, bcc := filterAddresses(msg.CC, keep), filterAddresses(msg.BCC, keep)
+		if err != nil {
+			return fmt.Errorf("checking opt-outs failed: %w", err)
+		}
+		if len(to) == 0 {
+			return Veto("every recipient unsubscribed from " + listName(list))
+		}
+		msg.To, msg.CC, msg.BCC = to, cc, bcc
+
+		if len(msg.To) == 1 {
+			msg.MimeHeaders, err = u.ListUnsubscribe(msg.To[0].Address, list).AddTo(msg.MimeHeaders)
+		}
+		return err
+	}
+}
+
+// UseUnsubscriber adds the Transformer of u for list to the transformers
+// of e. See Unsubscriber.Transformer.
+func (e *Email) UseUnsubscriber(u *Unsubscriber, list string) {
+	e.UseTransformers(u.Transformer(list))
+}
+
+func filterAddresses(addrs []EmailAddress, keep func(EmailAddress) bool) []EmailAddress {
+	var rv []EmailAddress
+	for _, a := range addrs {
+		if keep(a) {
+			rv = append(rv, a)
+		}
+	}
+	return rv
+}
+
+func listName(list string) string {
+	if list == "" {
+		return "all lists"
+	}
+	return fmt.Sprintf("%q", list)
+}
+
+var unsubscribePage = template.Must(template.New("unsubscribe").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Unsubscribe</title></head>
+<body>{{if .Done}}<p>{{.Address}} has been unsubscribed.</p>{{else}}
+<form method="post"><p>Unsubscribe {{.Address}}?</p><button type="submit">Unsubscribe</button></form>{{end}}
+</body></html>
+`))
+
+// ServeHTTP implements http.Handler.
+func (u *Unsubscriber) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	address, list, err := u.Verify(r.URL.Query().Get("token"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	done := r.Method == http.MethodPost
+	if done {
+		if err := u.store.OptOut(r.Context(), address, list); err != nil {
+			http.Error(w, "recording the opt-out failed", http.StatusInternalServerError)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_ = unsubscribePage.Execute(w, struct {
+		Address string
+		Done    bool
+	}{address, done})
+}
+
+// MemoryOptOutStore is an OptOutStore keeping opt-outs in memory, in a
+// SuppressionList backed by a MemorySuppressionStore.
+type MemoryOptOutStore struct {
+	list *SuppressionList
+}
+
+// NewMemoryOptOutStore returns an empty MemoryOptOutStore.
+func NewMemoryOptOutStore() *MemoryOptOutStore {
+	return &MemoryOptOutStore{list: NewSuppressionList(NewMemorySuppressionStore())}
+}
+
+// OptOut implements OptOutStore.
+func (s *MemoryOptOutStore) OptOut(ctx context.Context, address, list string) error {
+	return s.list.OptOut(ctx, address, list)
+}
+
+// IsOptedOut implements OptOutStore.
+func (s *MemoryOptOutStore) IsOptedOut(ctx context.Context, address, list string) (bool, error) {
+	return s.list.IsOptedOut(ctx, address, list)
+}
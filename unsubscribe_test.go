@@ -0,0 +1,161 @@
+package zeptomail_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/blancsoft/go-zeptomail"
+)
+
+func TestListUnsubscribeHeaders(t *testing.T) {
+	headers, err := zeptomail.ListUnsubscribe{
+		Mailto: "unsubscribe@example.com",
+		URL:    "https://example.com/u?t=1",
+	}.AddTo(zeptomail.Headers{"X-Other": "1"})
+	require.NoError(t, err)
+	assert.Equal(t, zeptomail.Headers{
+		"X-Other":               "1",
+		"List-Unsubscribe":      "<mailto:unsubscribe@example.com>, <https://example.com/u?t=1>",
+		"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+	}, headers)
+
+	mailtoOnly, err := zeptomail.ListUnsubscribe{Mailto: "mailto:u@example.com"}.Headers()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"List-Unsubscribe": "<mailto:u@example.com>"}, mailtoOnly)
+
+	_, err = zeptomail.ListUnsubscribe{URL: "http://example.com/u"}.Headers()
+	assert.ErrorContains(t, err, "https")
+}
+
+func TestUnsubscriber(t *testing.T) {
+	store := zeptomail.NewMemoryOptOutStore()
+	unsub, err := zeptomail.NewUnsubscriber(store, zeptomail.UnsubscribeConfig{
+		Secret: []byte("s3cret"),
+		URL:    "https://example.com/unsubscribe",
+		Mailto: "unsubscribe@example.com",
+	})
+	require.NoError(t, err)
+
+	token := unsub.Token("Ada@Example.com", "news")
+	address, list, err := unsub.Verify(token)
+	require.NoError(t, err)
+	assert.Equal(t, "ada@example.com", address)
+	assert.Equal(t, "news", list)
+
+	_, _, err = unsub.Verify(token[:len(token)-2] + "xx")
+	assert.ErrorIs(t, err, zeptomail.ErrInvalidUnsubscribeToken)
+
+	msg := &zeptomail.OutgoingMessage{
+		To: []zeptomail.OutgoingRecipient{{EmailAddress: zeptomail.EmailAddress{Address: "ada@example.com"}}},
+		CC: []zeptomail.EmailAddress{{Address: "bob@example.com"}},
+	}
+	transform := unsub.Transformer("news")
+	require.NoError(t, transform(context.Background(), msg))
+	header := msg.MimeHeaders.Get("list-unsubscribe")
+	assert.Contains(t, header, "<mailto:unsubscribe@example.com?subject=unsubscribe%20")
+	assert.Equal(t, "List-Unsubscribe=One-Click", msg.MimeHeaders["List-Unsubscribe-Post"])
+
+	link := header[strings.Index(header, "<https://")+1 : len(header)-1]
+	u, err := url.Parse(link)
+	require.NoError(t, err)
+	target := u.RequestURI()
+
+	t.Run("confirmation page", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		unsub.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), "<form method=\"post\">")
+		out, _ := unsub.IsOptedOut(t.Context(), "ada@example.com", "news")
+		assert.False(t, out, "a GET does not unsubscribe")
+	})
+
+	rec := httptest.NewRecorder()
+	unsub.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, target, strings.NewReader("List-Unsubscribe=One-Click")))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	out, err := unsub.IsOptedOut(t.Context(), "ADA@example.com", "news")
+	require.NoError(t, err)
+	assert.True(t, out)
+
+	rec = httptest.NewRecorder()
+	unsub.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/unsubscribe?token=forged", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	msg = &zeptomail.OutgoingMessage{
+		To: []zeptomail.OutgoingRecipient{
+			{EmailAddress: zeptomail.EmailAddress{Address: "ada@example.com"}},
+			{EmailAddress: zeptomail.EmailAddress{Address: "bob@example.com"}},
+		},
+	}
+	require.NoError(t, transform(context.Background(), msg))
+	require.Len(t, msg.To, 1, "opted-out recipients are dropped")
+	assert.Equal(t, "bob@example.com", msg.To[0].Address)
+
+	msg.To = msg.To[:0]
+	msg.To = append(msg.To, zeptomail.OutgoingRecipient{EmailAddress: zeptomail.EmailAddress{Address: "ada@example.com"}})
+	err = transform(context.Background(), msg)
+	assert.True(t, zeptomail.IsVetoed(err))
+
+	require.NoError(t, store.OptOut(t.Context(), "bob@example.com", ""))
+	out, err = unsub.IsOptedOut(t.Context(), "bob@example.com", "other")
+	require.NoError(t, err)
+	assert.True(t, out, "opting out of every list covers them all")
+}
+
+func TestEmailUseUnsubscriber(t *testing.T) {
+	var sent map[string]any
+	email := (*zeptomail.Email)(newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		sent = nil
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&sent))
+		acceptHandler(w, r)
+	}))
+	store, optOuts := zeptomail.NewMemorySuppressionStore(), zeptomail.NewMemorySuppressionStore()
+	suppressions := zeptomail.NewSuppressionList(store, optOuts)
+	unsub, err := zeptomail.NewUnsubscriber(suppressions, zeptomail.UnsubscribeConfig{
+		Secret: []byte("s3cret"),
+		URL:    "https://example.com/unsubscribe",
+	})
+	require.NoError(t, err)
+	email.UseUnsubscriber(unsub, "news")
+
+	require.NoError(t, suppressions.OptOut(t.Context(), other.Address, "news"))
+	s, err := suppressions.Lookup(t.Context(), other.Address)
+	require.NoError(t, err)
+	assert.Nil(t, s, "opting out of a list does not suppress other emails")
+	var exported strings.Builder
+	require.NoError(t, suppressions.ExportCSV(t.Context(), &exported))
+	assert.Equal(t, "address,reason,created_at,expires_at\n", exported.String(),
+		"list opt-outs are not exported as suppressions")
+	stored, err := store.List(t.Context())
+	require.NoError(t, err)
+	assert.Empty(t, stored)
+	stored, err = optOuts.List(t.Context())
+	require.NoError(t, err)
+	assert.Len(t, stored, 1)
+
+	_, err = email.SendBatchHTMLEmail(t.Context(), zeptomail.SendBatchHTMLEmailReq{
+		From: sender,
+		To: []zeptomail.SendBatchEmailTo{
+			{EmailAddress: receiver, MergeInfo: map[string]any{}},
+			{EmailAddress: other, MergeInfo: map[string]any{}},
+			{EmailAddress: zeptomail.EmailAddress{Address: "carol@example.com"}, MergeInfo: map[string]any{}},
+		},
+		Subject:  emailSubject,
+		HtmlBody: emailBody,
+	})
+	require.NoError(t, err)
+	assert.Len(t, sent["to"], 2, "opted-out recipients are dropped")
+	assert.NotContains(t, sent, "mime_headers", "shared headers can't name a single recipient")
+
+	require.NoError(t, suppressions.Suppress(t.Context(), receiver.Address, zeptomail.SuppressedUnsubscribe, 0))
+	out, err := unsub.IsOptedOut(t.Context(), receiver.Address, "news")
+	require.NoError(t, err)
+	assert.True(t, out, "suppressed addresses are opted out of every list")
+}
@@ -41,3 +41,9 @@ func NewZeptoMail(mailAgent, apiKey, oauthToken string) (*ZeptoMail, error) {
 		Template:  Template(*mgmtClient),
 	}, nil
 }
+
+// Bool returns a pointer to v, for the optional flags of requests such as
+// BaseEmailOption.TrackClicks.
+func Bool(v bool) *bool {
+	return &v
+}